@@ -3,6 +3,9 @@ module alb.go
 go 1.14
 
 require (
-	github.com/pulumi/pulumi-aws/sdk/v2 v2.0.0
-	github.com/pulumi/pulumi/sdk/v2 v2.0.0
+	github.com/jaxxstorm/iac-in-go/lib v0.0.0
+	github.com/pulumi/pulumi-aws/sdk/v2 v2.12.0
+	github.com/pulumi/pulumi/sdk/v2 v2.6.1
 )
+
+replace github.com/jaxxstorm/iac-in-go/lib => ../lib
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func newMocks() *mocks.Mocks {
+	return mocks.Default().SetConfig("alb.go:certificateId", "0123abcd")
+}
+
+func TestLoadBalancer(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("alb.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	alb, ok := m.Resource("aws:lb/loadBalancer:LoadBalancer", "web")
+	if !ok {
+		t.Fatal("load balancer was not created")
+	}
+	subnets := alb.Inputs["subnets"].ArrayValue()
+	if len(subnets) != 3 || subnets[0].StringValue() != "subnet-public-a" {
+		t.Errorf("load balancer should use the public subnets, got %v", subnets)
+	}
+
+	sg, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "web")
+	if !ok {
+		t.Fatal("web security group was not created")
+	}
+	for _, port := range []int{80, 443} {
+		open := false
+		for _, rule := range sg.Ingress() {
+			open = open || rule.Allows("0.0.0.0/0", port)
+		}
+		if !open {
+			t.Errorf("web security group does not allow port %d", port)
+		}
+	}
+	for _, rule := range sg.Ingress() {
+		if rule.Allows("0.0.0.0/0", 22) {
+			t.Errorf("web security group opens SSH: %+v", rule)
+		}
+	}
+}
+
+func TestHTTPRedirectsToHTTPS(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("alb.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, ok := m.Resource("aws:lb/listener:Listener", "http")
+	if !ok {
+		t.Fatal("http listener was not created")
+	}
+	action := listener.Inputs["defaultActions"].ArrayValue()[0].ObjectValue()
+	if action["type"].StringValue() != "redirect" {
+		t.Fatalf("http listener should redirect, got %v", action["type"])
+	}
+	redirect := action["redirect"].ObjectValue()
+	if redirect["protocol"].StringValue() != "HTTPS" || redirect["port"].StringValue() != "443" {
+		t.Errorf("http listener should redirect to HTTPS on 443, got %v", redirect)
+	}
+}
+
+func TestAccountAndRegion(t *testing.T) {
+	m := newMocks()
+	err := m.Run("alb.go", createStack, mocks.Config(map[string]string{
+		"aws:region": "eu-west-1",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, ok := m.Resource("aws:lb/listener:Listener", "https")
+	if !ok {
+		t.Fatal("https listener was not created")
+	}
+	want := "arn:aws:acm:eu-west-1:" + mocks.AccountID + ":certificate/0123abcd"
+	if arn := listener.Inputs["certificateArn"].StringValue(); arn != want {
+		t.Errorf("https listener certificate = %s, want %s", arn, want)
+	}
+
+	for _, r := range m.Resources("") {
+		if strings.HasPrefix(r.Type, "aws:") && r.Provider != "" {
+			t.Errorf("%s %s should be created with the default provider, got %s", r.Type, r.Name, r.Provider)
+		}
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("alb.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}
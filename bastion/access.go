@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/s3"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+const (
+	// ssmOnly only allows Session Manager, which needs no ingress
+	ssmOnly = "ssm-only"
+
+	// tailscaleAccess also lets tailnet peers in the VPC connect directly
+	tailscaleAccess = "tailscale"
+
+	// sshCidrs also allows SSH and ping from an allow-list
+	sshCidrs = "ssh-cidrs"
+
+	// tailscalePort is the UDP port tailscaled listens on for WireGuard
+	tailscalePort = 41641
+)
+
+// accessIngress returns the bastion's ingress rules for the access mode
+func accessIngress(mode string, cidrs []string, vpcCidr pulumi.StringInput) (ec2.SecurityGroupIngressArray, error) {
+	if mode != sshCidrs && len(cidrs) > 0 {
+		return nil, fmt.Errorf("sshCidrs are only used in %s access mode", sshCidrs)
+	}
+
+	switch mode {
+	case ssmOnly:
+		return ec2.SecurityGroupIngressArray{}, nil
+	case tailscaleAccess:
+		return ec2.SecurityGroupIngressArray{
+			&ec2.SecurityGroupIngressArgs{
+				Description: pulumi.String("Tailscale from peers in the VPC"),
+				Protocol:    pulumi.String("udp"),
+				FromPort:    pulumi.Int(tailscalePort),
+				ToPort:      pulumi.Int(tailscalePort),
+				CidrBlocks:  pulumi.StringArray{vpcCidr},
+			},
+		}, nil
+	case sshCidrs:
+		if len(cidrs) == 0 {
+			return nil, fmt.Errorf("%s access mode needs an allow-list of sshCidrs", sshCidrs)
+		}
+		for _, cidr := range cidrs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("SSH CIDR %q: %w", cidr, err)
+			}
+			if ones, _ := network.Mask.Size(); ones == 0 {
+				return nil, fmt.Errorf("SSH CIDR %q opens the bastion to the whole internet", cidr)
+			}
+		}
+
+		allowed := pulumi.StringArray(policy.Strings(cidrs...))
+		return ec2.SecurityGroupIngressArray{
+			&ec2.SecurityGroupIngressArgs{
+				Description: pulumi.String("SSH from the allow-list"),
+				Protocol:    pulumi.String("tcp"),
+				FromPort:    pulumi.Int(22),
+				ToPort:      pulumi.Int(22),
+				CidrBlocks:  allowed,
+			},
+			&ec2.SecurityGroupIngressArgs{
+				Description: pulumi.String("Ping from the allow-list"),
+				Protocol:    pulumi.String("icmp"),
+				FromPort:    pulumi.Int(-1),
+				ToPort:      pulumi.Int(-1),
+				CidrBlocks:  allowed,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("access mode must be %q, %q or %q, got %q", ssmOnly, tailscaleAccess, sshCidrs, mode)
+	}
+}
+
+// sessionPreferencesDocument is the document Session Manager reads the
+// account's preferences from
+const sessionPreferencesDocument = "SSM-SessionManagerRunShell"
+
+// errAdoptUnmanaged is returned when the preferences document is adopted by a
+// stack which doesn't manage it
+var errAdoptUnmanaged = errors.New("bastion.go:adoptSessionPreferences needs bastion.go:manageSessionPreferences")
+
+// newSessionLogging creates the bucket and log group Session Manager logs the
+// bastion's sessions to, and lets the bastion write the logs. The preferences
+// which point sessions at them are per account and region, so the stack only
+// sets them with manage. The console creates the preferences document when
+// they're first saved, so with adopt set, the existing document is imported
+// unchanged, and the preferences are applied on the next update without adopt
+func newSessionLogging(ctx *pulumi.Context, role *iam.Role, retentionDays int, manage, adopt bool) (*s3.Bucket, *cloudwatch.LogGroup, error) {
+	if retentionDays <= 0 {
+		return nil, nil, fmt.Errorf("session log retention must be positive, got %d days", retentionDays)
+	}
+	if adopt && !manage {
+		return nil, nil, errAdoptUnmanaged
+	}
+
+	bucket, err := s3.NewBucket(ctx, "session-logs", &s3.BucketArgs{
+		Acl: pulumi.String("private"),
+		ServerSideEncryptionConfiguration: &s3.BucketServerSideEncryptionConfigurationArgs{
+			Rule: &s3.BucketServerSideEncryptionConfigurationRuleArgs{
+				ApplyServerSideEncryptionByDefault: &s3.BucketServerSideEncryptionConfigurationRuleApplyServerSideEncryptionByDefaultArgs{
+					SseAlgorithm: pulumi.String("AES256"),
+				},
+			},
+		},
+		LifecycleRules: s3.BucketLifecycleRuleArray{
+			&s3.BucketLifecycleRuleArgs{
+				Enabled: pulumi.Bool(true),
+				Expiration: &s3.BucketLifecycleRuleExpirationArgs{
+					Days: pulumi.Int(retentionDays),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logGroup, err := cloudwatch.NewLogGroup(ctx, "session-logs", &cloudwatch.LogGroupArgs{
+		RetentionInDays: pulumi.Int(retentionDays),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if manage {
+		if err := newSessionPreferences(ctx, bucket, logGroup, adopt); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	/*
+	 * The SSM agent on the bastion uploads the
+	 * logs with the instance's credentials
+	 */
+	sessionLogsPolicyJSON, err := policy.Document{
+		Statement: []policy.Statement{
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"s3:PutObject"},
+				Resource: []pulumi.StringInput{pulumi.Sprintf("%s/sessions/*", bucket.Arn)},
+			},
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"s3:GetEncryptionConfiguration"},
+				Resource: []pulumi.StringInput{bucket.Arn},
+			},
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"logs:DescribeLogGroups"},
+				Resource: policy.Strings("*"),
+			},
+			{
+				Effect: policy.Allow,
+				Action: []string{
+					"logs:CreateLogStream",
+					"logs:PutLogEvents",
+					"logs:DescribeLogStreams",
+				},
+				Resource: []pulumi.StringInput{
+					logGroup.Arn,
+					pulumi.Sprintf("%s:*", logGroup.Arn),
+				},
+			},
+		},
+	}.Render()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "session-logs", &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: sessionLogsPolicyJSON,
+	}, pulumi.Parent(role))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return bucket, logGroup, nil
+}
+
+// newSessionPreferences sets the account's Session Manager preferences in the
+// stack's region, so every session is logged to the bucket and log group
+func newSessionPreferences(ctx *pulumi.Context, bucket *s3.Bucket, logGroup *cloudwatch.LogGroup, adopt bool) error {
+	/*
+	 * Session Manager reads its preferences
+	 * from this document, in each region
+	 */
+	content := pulumi.All(bucket.ID(), logGroup.Name).ApplyT(func(args []interface{}) (string, error) {
+		preferences, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": "1.0",
+			"description":   "Session Manager preferences",
+			"sessionType":   "Standard_Stream",
+			"inputs": map[string]interface{}{
+				"s3BucketName":                args[0],
+				"s3KeyPrefix":                 "sessions",
+				"s3EncryptionEnabled":         true,
+				"cloudWatchLogGroupName":      args[1],
+				"cloudWatchEncryptionEnabled": false,
+				"cloudWatchStreamingEnabled":  true,
+				"idleSessionTimeout":          "20",
+			},
+		})
+		return string(preferences), err
+	}).(pulumi.StringOutput)
+
+	/*
+	 * The document holds the preferences for the
+	 * account in the region, so it's protected from
+	 * being deleted with the stack
+	 */
+	documentOpts := []pulumi.ResourceOption{pulumi.Protect(true)}
+	if adopt {
+		// an import fails if the inputs differ from the live document
+		documentOpts = append(documentOpts,
+			pulumi.Import(pulumi.ID(sessionPreferencesDocument)),
+			pulumi.IgnoreChanges([]string{"content", "tags"}))
+	}
+
+	_, err := ssm.NewDocument(ctx, "session-manager-preferences", &ssm.DocumentArgs{
+		Name:           pulumi.String(sessionPreferencesDocument),
+		DocumentType:   pulumi.String("Session"),
+		DocumentFormat: pulumi.String("JSON"),
+		Content:        content,
+	}, documentOpts...)
+	return err
+}
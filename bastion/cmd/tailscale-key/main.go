@@ -0,0 +1,64 @@
+// Command tailscale-key creates an auth key for the bastion with the
+// Tailscale API. It prints the new key on stdout, to be saved in the stack's
+// tailScaleHostKey config secret, and its ID on stderr. With -revoke it
+// revokes the key it replaced instead, once every router has been refreshed
+// with the new one
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"bastion.go/tailscale"
+)
+
+func main() {
+	var (
+		tailnet   = flag.String("tailnet", os.Getenv("TAILSCALE_TAILNET"), "tailnet to create the key in")
+		reusable  = flag.Bool("reusable", true, "let the key register more than one node")
+		ephemeral = flag.Bool("ephemeral", false, "remove nodes from the tailnet once they go offline, not for subnet routers")
+		tags      = flag.String("tags", "", "comma separated ACL tags, e.g. tag:bastion")
+		expiry    = flag.Duration("expiry", 90*24*time.Hour, "how long the key can be used for")
+		revoke    = flag.String("revoke", "", "ID of a replaced key to revoke, instead of creating one")
+	)
+	flag.Parse()
+
+	client := &tailscale.Client{
+		BaseURL: os.Getenv("TAILSCALE_BASE_URL"),
+		APIKey:  os.Getenv("TAILSCALE_API_KEY"),
+		Tailnet: *tailnet,
+	}
+	if client.APIKey == "" || client.Tailnet == "" {
+		fmt.Fprintln(os.Stderr, "TAILSCALE_API_KEY and a tailnet are required")
+		os.Exit(2)
+	}
+
+	if *revoke != "" {
+		if err := client.DeleteKey(*revoke); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "revoked key %s\n", *revoke)
+		return
+	}
+
+	options := tailscale.KeyOptions{
+		Reusable:  *reusable,
+		Ephemeral: *ephemeral,
+		Expiry:    *expiry,
+	}
+	if *tags != "" {
+		options.Tags = strings.Split(*tags, ",")
+	}
+
+	key, err := client.CreateKey(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "created key %s, expires %s\n", key.ID, key.Expires.Format(time.RFC3339))
+	fmt.Println(key.Key)
+}
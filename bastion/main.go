@@ -1,256 +1,334 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
 	"io/ioutil"
 
+	"github.com/jaxxstorm/iac-in-go/lib/awsprovider"
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/jaxxstorm/iac-in-go/lib/tags"
 	"github.com/pulumi/pulumi/sdk/v2/go/pulumi/config"
 
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/ssm"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ssm"
 
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws"
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/autoscaling"
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/ec2"
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/iam"
 	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
 )
 
 func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
-
-		config := config.New(ctx, "")
-		tailScaleHostKey := config.Require("tailScaleHostKey")
-
-		/*
-		 * Grab the vpc cluster stack outputs
-		 */
-		vpcSlug := fmt.Sprintf("jaxxstorm/vpc.go/%v", ctx.Stack())
-		vpc, err := pulumi.NewStackReference(ctx, vpcSlug, nil)
-		if err != nil {
-			return fmt.Errorf("Error getting vpc stack reference: %w", err)
-		}
-
-		/*
-		 * Store the tailscale auth key in AWS SSM
-		 */
-
-		tailScaleKeyParameter, err := ssm.NewParameter(ctx, "tailscale-auth-key", &ssm.ParameterArgs{
-			Name:  pulumi.String("tailscale-auth-key"),
-			Type:  pulumi.String("SecureString"),
-			Value: pulumi.String(tailScaleHostKey),
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
+	pulumi.Run(createStack)
+}
+
+func createStack(ctx *pulumi.Context) error {
+	/*
+	 * Tag every AWS resource in the stack
+	 */
+	if err := tags.Register(ctx); err != nil {
+		return err
+	}
+
+	/*
+	 * Look up the account and region
+	 * the stack deploys to
+	 */
+	account, err := awsprovider.Lookup(ctx)
+	if err != nil {
+		return err
+	}
+
+	config := config.New(ctx, "")
+	tailScaleHostKey := config.RequireSecret("tailScaleHostKey")
+	keyName := config.Get("keyName")
+	instanceType := config.Get("instanceType")
+	if instanceType == "" {
+		instanceType = "t2.micro"
+	}
+
+	var routers routerConfig
+	if err := config.GetObject("routers", &routers); err != nil {
+		return err
+	}
+	if err := routers.validate(); err != nil {
+		return err
+	}
+
+	/*
+	 * Grab the vpc cluster stack outputs
+	 */
+	vpc, err := stackref.Vpc(ctx)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Store the tailscale auth key in AWS SSM
+	 */
+
+	tailScaleKeyParameter, err := ssm.NewParameter(ctx, "tailscale-auth-key", &ssm.ParameterArgs{
+		Name:  pulumi.String("tailscale-auth-key"),
+		Type:  pulumi.String("SecureString"),
+		Value: tailScaleHostKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * IAM policy principal
+	 */
+	assumeRolePolicyJSON, err := policy.ServiceAssumeRole("ec2.amazonaws.com", "ssm.amazonaws.com").Render()
+	if err != nil {
+		return err
+	}
+
+	bastionSSMPolicyJSON, err := policy.Document{
+		Statement: []policy.Statement{
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"ssm:GetParameters"},
+				Resource: []pulumi.StringInput{tailScaleKeyParameter.Arn},
 			},
-		})
-
-		/*
-		 * IAM policy principal
-		 */
-		assumeRolePolicyJSON, _ := json.Marshal(map[string]interface{}{
-			"Version": "2012-10-17",
-			"Statement": []interface{}{
-				map[string]interface{}{
-					"Action": "sts:AssumeRole",
-					"Principal": map[string]interface{}{
-						"Service": []interface{}{
-							"ec2.amazonaws.com",
-							"ssm.amazonaws.com",
-						},
-					},
-					"Effect": "Allow",
-				},
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"ssm:DescribeParameters"},
+				Resource: policy.Strings("*"),
 			},
-		})
-
-		bastionSSMPolicyJSON := tailScaleKeyParameter.Arn.ApplyT(func(arn string) (string, error) {
-			policyJSON, err := json.Marshal(map[string]interface{}{
-				"Version": "2012-10-17",
-				"Statement": []interface{}{
-					map[string]interface{}{
-						"Action": []string{
-							"ssm:GetParameters",
-						},
-						"Effect": "Allow",
-						"Resource": []string{
-							arn,
-						},
-					},
-					map[string]interface{}{
-						"Action": []string{
-							"ssm:DescribeParameters",
-						},
-						"Effect":   "Allow",
-						"Resource": "*",
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"autoscaling:SetInstanceHealth"},
+				Resource: policy.Strings("*"),
+				Condition: []policy.Condition{
+					{
+						Test:     "StringEquals",
+						Variable: pulumi.String("autoscaling:ResourceTag/Name"),
+						Values:   policy.Strings(nameTag),
 					},
 				},
-			})
-			if err != nil {
-				return "", err
-			}
-			return string(policyJSON), nil
-		})
-
-		/*
-		 * Create the IAM role that allows talking to EC2 and SSM
-		 */
-		bastionIAMRole, err := iam.NewRole(ctx, "bastion", &iam.RoleArgs{
-			AssumeRolePolicy: pulumi.String(assumeRolePolicyJSON),
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
 			},
-		})
-		if err != nil {
-			return err
-		}
-
-		/*
-		 * Attach the policy to the role that allows running on ECS
-		 */
-		_, err = iam.NewRolePolicyAttachment(ctx, "ssm-managed-instance-policy", &iam.RolePolicyAttachmentArgs{
-			Role:      bastionIAMRole.Name,
-			PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
-		}, pulumi.Parent(bastionIAMRole))
-		if err != nil {
-			return err
-		}
-
-		/*
-		 * Attach a policy that allows the instances to retrieve
-		 * parameters from the parameter store
-		 */
-		ssmPolicy, err := iam.NewPolicy(ctx, "bastion-ssm-access", &iam.PolicyArgs{
-			Policy: bastionSSMPolicyJSON,
-		}, pulumi.Parent(bastionIAMRole))
-		if err != nil {
-			return err
-		}
-
-		_, err = iam.NewRolePolicyAttachment(ctx, "ssm-get-parameters", &iam.RolePolicyAttachmentArgs{
-			Role:      bastionIAMRole.Name,
-			PolicyArn: ssmPolicy.Arn,
-		}, pulumi.Parent(ssmPolicy))
-
-		/*
-		 * Create an IAM instance profile to assign to the ASG
-		 */
-		bastionIAMInstanceProfile, err := iam.NewInstanceProfile(ctx, "bastion", &iam.InstanceProfileArgs{
-			Role: bastionIAMRole.Name,
-		}, pulumi.Parent(bastionIAMRole))
-		if err != nil {
-			return err
-		}
-
-		/*
-		 * Create a security group for the bastion traffic
-		 */
-		bastionSecurityGroup, err := ec2.NewSecurityGroup(ctx, "bastion", &ec2.SecurityGroupArgs{
-			Description: pulumi.String("Allow egress traffic for bastion host"),
-			VpcId:       vpc.GetStringOutput(pulumi.String("id")),
-			Ingress: &ec2.SecurityGroupIngressArray{
-				&ec2.SecurityGroupIngressArgs{
-					Protocol: pulumi.String("icmp"),
-					FromPort: pulumi.Int(0),
-					ToPort:   pulumi.Int(0),
-					CidrBlocks: pulumi.StringArray{
-						pulumi.String("0.0.0.0/0"),
-					},
-				},
-				&ec2.SecurityGroupIngressArgs{
-					Protocol: pulumi.String("tcp"),
-					FromPort: pulumi.Int(22),
-					ToPort:   pulumi.Int(22),
-					CidrBlocks: pulumi.StringArray{
-						pulumi.String("0.0.0.0/0"),
-					},
+		},
+	}.Render()
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Create the IAM role that allows talking to EC2 and SSM
+	 */
+	bastionIAMRole, err := iam.NewRole(ctx, "bastion", &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicyJSON,
+	})
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Attach the policy to the role that allows running on ECS
+	 */
+	_, err = iam.NewRolePolicyAttachment(ctx, "ssm-managed-instance-policy", &iam.RolePolicyAttachmentArgs{
+		Role:      bastionIAMRole.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
+	}, pulumi.Parent(bastionIAMRole))
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Attach a policy that allows the instances to retrieve
+	 * parameters from the parameter store, and report
+	 * their own health
+	 */
+	ssmPolicy, err := iam.NewPolicy(ctx, "bastion-ssm-access", &iam.PolicyArgs{
+		Policy: bastionSSMPolicyJSON,
+	}, pulumi.Parent(bastionIAMRole))
+	if err != nil {
+		return err
+	}
+
+	_, err = iam.NewRolePolicyAttachment(ctx, "ssm-get-parameters", &iam.RolePolicyAttachmentArgs{
+		Role:      bastionIAMRole.Name,
+		PolicyArn: ssmPolicy.Arn,
+	}, pulumi.Parent(ssmPolicy))
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Create an IAM instance profile to assign to the ASG
+	 */
+	bastionIAMInstanceProfile, err := iam.NewInstanceProfile(ctx, "bastion", &iam.InstanceProfileArgs{
+		Role: bastionIAMRole.Name,
+	}, pulumi.Parent(bastionIAMRole))
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Only open the bastion to what the access
+	 * mode needs, Session Manager needs nothing
+	 */
+	accessMode := config.Get("accessMode")
+	if accessMode == "" {
+		accessMode = tailscaleAccess
+	}
+	var allowedSSHCidrs []string
+	if err := config.GetObject("sshCidrs", &allowedSSHCidrs); err != nil {
+		return err
+	}
+
+	ingress, err := accessIngress(accessMode, allowedSSHCidrs, vpc.Cidr)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Log every Session Manager session
+	 */
+	sessionLogRetentionDays := config.GetInt("sessionLogRetentionDays")
+	if sessionLogRetentionDays == 0 {
+		sessionLogRetentionDays = 90
+	}
+	manageSessionPreferences := config.GetBool("manageSessionPreferences")
+	adoptSessionPreferences := config.GetBool("adoptSessionPreferences")
+	sessionLogBucket, sessionLogGroup, err := newSessionLogging(ctx, bastionIAMRole, sessionLogRetentionDays,
+		manageSessionPreferences, adoptSessionPreferences)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Create a security group for the bastion traffic
+	 */
+	bastionSecurityGroup, err := ec2.NewSecurityGroup(ctx, "bastion", &ec2.SecurityGroupArgs{
+		Description: pulumi.String("Allow egress traffic for bastion host"),
+		VpcId:       vpc.ID,
+		Ingress:     ingress,
+		Egress: &ec2.SecurityGroupEgressArray{
+			&ec2.SecurityGroupEgressArgs{
+				Protocol: pulumi.String("-1"),
+				FromPort: pulumi.Int(0),
+				ToPort:   pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{
+					pulumi.String("0.0.0.0/0"),
 				},
 			},
-			Egress: &ec2.SecurityGroupEgressArray{
-				&ec2.SecurityGroupEgressArgs{
-					Protocol: pulumi.String("-1"),
-					FromPort: pulumi.Int(0),
-					ToPort:   pulumi.Int(0),
-					CidrBlocks: pulumi.StringArray{
-						pulumi.String("0.0.0.0/0"),
-					},
-				},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Retrieve the AMI
+	 */
+	mostRecent := true
+	ami, err := ec2.LookupAmi(ctx, &ec2.LookupAmiArgs{
+		Filters: []ec2.GetAmiFilter{
+			{
+				Name:   "owner-alias",
+				Values: []string{"amazon"},
 			},
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
+			{
+				Name:   "name",
+				Values: []string{"amzn2-ami-hvm*"},
 			},
-		})
-		if err != nil {
-			return err
-		}
-
-		/*
-		 * Retrieve the AMI
-		 */
-		mostRecent := true
-		ami, err := aws.GetAmi(ctx, &aws.GetAmiArgs{
-			Filters: []aws.GetAmiFilter{
-				{
-					Name:   "owner-alias",
-					Values: []string{"amazon"},
-				},
-				{
-					Name:   "name",
-					Values: []string{"amzn2-ami-hvm*"},
+		},
+		Owners:     []string{"amazon"},
+		MostRecent: &mostRecent,
+	})
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Render the userdata, advertising the VPC to
+	 * the tailnet with the key from SSM
+	 */
+	userDataTemplate, err := ioutil.ReadFile("userdata.tmpl")
+	if err != nil {
+		return err
+	}
+
+	tailscaleHostname := config.Get("tailscaleHostname")
+	if tailscaleHostname == "" {
+		tailscaleHostname = nameTag
+	}
+	var tailscaleTags []string
+	if err := config.GetObject("tailscaleTags", &tailscaleTags); err != nil {
+		return err
+	}
+
+	bastionUserData := userData(string(userDataTemplate), vpc.Cidr, tailScaleKeyParameter.Name, tailScaleHostKey, userDataValues{
+		Region:       account.Region,
+		Hostname:     tailscaleHostname,
+		ZoneHostname: routers.Count > 1,
+		Tags:         tailscaleTags,
+	})
+
+	/*
+	 * Create a launch template which requires IMDSv2 and
+	 * encrypts the root volume. Every change to it is
+	 * saved as a new version
+	 */
+	templateArgs := &ec2.LaunchTemplateArgs{
+		ImageId:      pulumi.String(ami.Id),
+		InstanceType: pulumi.String(instanceType),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileArgs{
+			Arn: bastionIAMInstanceProfile.Arn,
+		},
+		NetworkInterfaces: ec2.LaunchTemplateNetworkInterfaceArray{
+			&ec2.LaunchTemplateNetworkInterfaceArgs{
+				AssociatePublicIpAddress: pulumi.String("false"),
+				DeleteOnTermination:      pulumi.String("true"),
+				SecurityGroups: pulumi.StringArray{
+					bastionSecurityGroup.ID(),
 				},
 			},
-			Owners:     []string{"amazon"},
-			MostRecent: &mostRecent,
-		})
-		if err != nil {
-			return err
-		}
-
-		userData, err := ioutil.ReadFile("userdata.init")
-		if err != nil {
-			return err
-		}
-
-		/*
-		 * Create an AWS Launch Configuration
-		 */
-		bastionLaunchConfiguration, err := ec2.NewLaunchConfiguration(ctx, "bastion", &ec2.LaunchConfigurationArgs{
-			InstanceType: pulumi.String("t2.micro"),
-			SecurityGroups: pulumi.StringArray{
-				bastionSecurityGroup.ID(),
-			},
-			AssociatePublicIpAddress: pulumi.Bool(false),
-			ImageId:                  pulumi.String(ami.Id),
-			IamInstanceProfile:       bastionIAMInstanceProfile.ID(),
-			UserData:                 pulumi.String(base64.StdEncoding.EncodeToString(userData)),
-			KeyName:                  pulumi.String("lbriggs"),
-		})
-		if err != nil {
-			return err
-		}
-
-		bastionAutoScalingGroup, err := autoscaling.NewGroup(ctx, "bastion", &autoscaling.GroupArgs{
-			LaunchConfiguration:    bastionLaunchConfiguration.ID(),
-			MaxSize:                pulumi.Int(1),
-			MinSize:                pulumi.Int(1),
-			HealthCheckType:        pulumi.String("EC2"),
-			HealthCheckGracePeriod: pulumi.Int(30),
-			VpcZoneIdentifiers:     pulumi.StringArrayOutput(vpc.GetOutput(pulumi.String("privateSubnets"))),
-			Tags: &autoscaling.GroupTagArray{
-				&autoscaling.GroupTagArgs{
-					Key:               pulumi.String("Owner"),
-					Value:             pulumi.String("lbriggs"),
-					PropagateAtLaunch: pulumi.Bool(true),
-				},
-				&autoscaling.GroupTagArgs{
-					Key:               pulumi.String("Name"),
-					Value:             pulumi.String("lbriggs-bastion"),
-					PropagateAtLaunch: pulumi.Bool(true),
+		},
+		MetadataOptions: &ec2.LaunchTemplateMetadataOptionsArgs{
+			HttpEndpoint:            pulumi.String("enabled"),
+			HttpTokens:              pulumi.String("required"),
+			HttpPutResponseHopLimit: pulumi.Int(1),
+		},
+		BlockDeviceMappings: ec2.LaunchTemplateBlockDeviceMappingArray{
+			&ec2.LaunchTemplateBlockDeviceMappingArgs{
+				DeviceName: pulumi.String(ami.RootDeviceName),
+				Ebs: &ec2.LaunchTemplateBlockDeviceMappingEbsArgs{
+					Encrypted:           pulumi.String("true"),
+					VolumeType:          pulumi.String("gp2"),
+					DeleteOnTermination: pulumi.String("true"),
 				},
 			},
-		}, pulumi.Parent(bastionLaunchConfiguration))
+		},
+		UserData: bastionUserData,
+	}
+	if keyName != "" {
+		templateArgs.KeyName = pulumi.String(keyName)
+	}
 
-		ctx.Export("autoScalingGroupName", bastionAutoScalingGroup.Name)
-		return nil
-	})
+	bastionLaunchTemplate, err := ec2.NewLaunchTemplate(ctx, "bastion", templateArgs)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Run the subnet routers
+	 */
+	bastionAutoScalingGroups, err := newRouters(ctx, bastionLaunchTemplate, vpc.PrivateSubnets, routers)
+	if err != nil {
+		return err
+	}
+
+	autoScalingGroupNames := pulumi.StringArray{}
+	for _, group := range bastionAutoScalingGroups {
+		autoScalingGroupNames = append(autoScalingGroupNames, group.Name)
+	}
+
+	ctx.Export("autoScalingGroupName", bastionAutoScalingGroups[0].Name)
+	ctx.Export("autoScalingGroupNames", autoScalingGroupNames)
+	ctx.Export("securityGroupId", bastionSecurityGroup.ID())
+	ctx.Export("sessionLogBucket", sessionLogBucket.ID())
+	ctx.Export("sessionLogGroup", sessionLogGroup.Name)
+	return nil
 }
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+	"github.com/pulumi/pulumi/sdk/v2/go/common/resource"
+)
+
+func newMocks() *mocks.Mocks {
+	return mocks.Default().
+		SetCallResult("aws:ec2/getAmi:getAmi", map[string]interface{}{
+			"id":             "ami-0123456789abcdef0",
+			"rootDeviceName": "/dev/xvda",
+		}).
+		SetCallResult("aws:ec2/getInstances:getInstances", map[string]interface{}{
+			"ids": []interface{}{"i-0123456789abcdef0"},
+		}).
+		SetResourceOutputs("aws:ec2/launchTemplate:LaunchTemplate", map[string]interface{}{"latestVersion": 3}).
+		SetResourceOutputs("aws:autoscaling/group:Group", map[string]interface{}{"name": "lbriggs-bastion"})
+}
+
+var config = mocks.Config(map[string]string{
+	"bastion.go:tailScaleHostKey": "tskey-0123456789",
+})
+
+func TestBastion(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("bastion.go", createStack, config); err != nil {
+		t.Fatal(err)
+	}
+
+	parameter, ok := m.Resource("aws:ssm/parameter:Parameter", "tailscale-auth-key")
+	if !ok {
+		t.Fatal("tailscale auth key parameter was not created")
+	}
+	if typ := parameter.Inputs["type"].StringValue(); typ != "SecureString" {
+		t.Errorf("tailscale auth key should be a SecureString, got %s", typ)
+	}
+
+	launchTemplate, ok := m.Resource("aws:ec2/launchTemplate:LaunchTemplate", "bastion")
+	if !ok {
+		t.Fatal("launch template was not created")
+	}
+	for _, ni := range launchTemplate.Inputs["networkInterfaces"].ArrayValue() {
+		if ni.ObjectValue()["associatePublicIpAddress"].StringValue() != "false" {
+			t.Error("bastion should not have a public IP address")
+		}
+	}
+	if image := launchTemplate.Inputs["imageId"].StringValue(); image != "ami-0123456789abcdef0" {
+		t.Errorf("bastion should use the looked up AMI, got %s", image)
+	}
+
+	asg, ok := m.Resource("aws:autoscaling/group:Group", "bastion")
+	if !ok {
+		t.Fatal("autoscaling group was not created")
+	}
+	for _, subnet := range asg.Inputs["vpcZoneIdentifiers"].ArrayValue() {
+		if !strings.HasPrefix(subnet.StringValue(), "subnet-private") {
+			t.Errorf("bastion should only run in private subnets, got %s", subnet.StringValue())
+		}
+	}
+	if _, ok := asg.Inputs["tagsCollection"]; ok {
+		t.Error("the provider rejects tagsCollection alongside tags, the stack tags should be added to tags")
+	}
+	if tags := asg.Tags(); tags["Name"] != nameTag || tags["Owner"] == "" {
+		t.Errorf("autoscaling group should keep its Name tag and get the stack tags, got %v", tags)
+	}
+}
+
+func TestLaunchTemplate(t *testing.T) {
+	m := newMocks().SetResourceOutputs("aws:ec2/launchTemplate:LaunchTemplate", map[string]interface{}{
+		"latestVersion": 3,
+	})
+	if err := m.Run("bastion.go", createStack, config); err != nil {
+		t.Fatal(err)
+	}
+
+	launchTemplate, _ := m.Resource("aws:ec2/launchTemplate:LaunchTemplate", "bastion")
+	if tokens := launchTemplate.Inputs["metadataOptions"].ObjectValue()["httpTokens"].StringValue(); tokens != "required" {
+		t.Errorf("bastion should require IMDSv2, got httpTokens %q", tokens)
+	}
+	root := launchTemplate.Inputs["blockDeviceMappings"].ArrayValue()[0].ObjectValue()
+	if device := root["deviceName"].StringValue(); device != "/dev/xvda" {
+		t.Errorf("root volume device = %s, want /dev/xvda", device)
+	}
+	if encrypted := root["ebs"].ObjectValue()["encrypted"].StringValue(); encrypted != "true" {
+		t.Error("root volume should be encrypted")
+	}
+	if instanceType := launchTemplate.Inputs["instanceType"].StringValue(); instanceType != "t2.micro" {
+		t.Errorf("instance type = %s, want t2.micro", instanceType)
+	}
+	if _, ok := launchTemplate.Inputs["keyName"]; ok {
+		t.Error("no key pair should be set by default")
+	}
+
+	asg, _ := m.Resource("aws:autoscaling/group:Group", "bastion")
+	if version := asg.Inputs["launchTemplate"].ObjectValue()["version"].StringValue(); version != "3" {
+		t.Errorf("autoscaling group should use the latest template version, got %s", version)
+	}
+	if _, ok := asg.Inputs["name"]; ok {
+		t.Error("autoscaling group should keep its name across template versions")
+	}
+	refresh := asg.Inputs["instanceRefresh"].ObjectValue()
+	if strategy := refresh["strategy"].StringValue(); strategy != "Rolling" {
+		t.Errorf("instance refresh strategy = %s, want Rolling", strategy)
+	}
+	if version := asg.Inputs["launchTemplate"].ObjectValue()["version"].StringValue(); version != "3" {
+		t.Errorf("bastion should run the template's latest version, got %s", version)
+	}
+}
+
+func TestInstanceConfig(t *testing.T) {
+	m := newMocks()
+	err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+		"bastion.go:tailScaleHostKey": "tskey-0123456789",
+		"bastion.go:instanceType":     "t3.small",
+		"bastion.go:keyName":          "ops",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	launchTemplate, _ := m.Resource("aws:ec2/launchTemplate:LaunchTemplate", "bastion")
+	if instanceType := launchTemplate.Inputs["instanceType"].StringValue(); instanceType != "t3.small" {
+		t.Errorf("instance type = %s, want t3.small", instanceType)
+	}
+	if keyName := launchTemplate.Inputs["keyName"].StringValue(); keyName != "ops" {
+		t.Errorf("key name = %s, want ops", keyName)
+	}
+}
+
+// plain unwraps an input the program marked secret
+func plain(v resource.PropertyValue) resource.PropertyValue {
+	if v.IsSecret() {
+		return v.SecretValue().Element
+	}
+	return v
+}
+
+func TestKeyRotation(t *testing.T) {
+	run := func(key string) (string, string) {
+		m := newMocks()
+		err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+			"bastion.go:tailScaleHostKey": key,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parameter, _ := m.Resource("aws:ssm/parameter:Parameter", "tailscale-auth-key")
+		launchTemplate, _ := m.Resource("aws:ec2/launchTemplate:LaunchTemplate", "bastion")
+		return plain(parameter.Inputs["value"]).StringValue(), plain(launchTemplate.Inputs["userData"]).StringValue()
+	}
+
+	oldValue, oldUserData := run("tskey-0123456789")
+	newValue, newUserData := run("tskey-9876543210")
+
+	if oldValue == newValue {
+		t.Error("a new key should update the parameter")
+	}
+	if oldUserData == newUserData {
+		t.Error("a new key should change the launch template, so the bastion is replaced")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(newUserData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(decoded), "tskey") {
+		t.Error("the key should not be in the userdata")
+	}
+}
+
+func TestAccessModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		allowed map[string]int
+	}{
+		{
+			name:    "tailscale",
+			allowed: map[string]int{"172.1.0.0/16": tailscalePort},
+		},
+		{
+			name:   "ssm-only",
+			config: map[string]string{"bastion.go:accessMode": "ssm-only"},
+		},
+		{
+			name: "ssh-cidrs",
+			config: map[string]string{
+				"bastion.go:accessMode": "ssh-cidrs",
+				"bastion.go:sshCidrs":   `["203.0.113.0/24"]`,
+			},
+			allowed: map[string]int{"203.0.113.0/24": 22},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := map[string]string{"bastion.go:tailScaleHostKey": "tskey-0123456789"}
+			for k, v := range tt.config {
+				values[k] = v
+			}
+
+			m := newMocks()
+			if err := m.Run("bastion.go", createStack, mocks.Config(values)); err != nil {
+				t.Fatal(err)
+			}
+
+			sg, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "bastion")
+			if !ok {
+				t.Fatal("bastion security group was not created")
+			}
+			rules := sg.Ingress()
+			for _, rule := range rules {
+				if rule.Allows("0.0.0.0/0", 22) || rule.Allows("0.0.0.0/0", tailscalePort) || rule.Allows("0.0.0.0/0", -1) {
+					t.Errorf("bastion should not be open to the world, got %+v", rule)
+				}
+			}
+			for cidr, port := range tt.allowed {
+				allowed := false
+				for _, rule := range rules {
+					allowed = allowed || rule.Allows(cidr, port)
+				}
+				if !allowed {
+					t.Errorf("bastion should allow %s on port %d, got %+v", cidr, port, rules)
+				}
+			}
+			if len(tt.allowed) == 0 && len(rules) != 0 {
+				t.Errorf("bastion should have no ingress, got %+v", rules)
+			}
+		})
+	}
+}
+
+func TestInvalidAccessMode(t *testing.T) {
+	for name, config := range map[string]map[string]string{
+		"unknown mode":  {"bastion.go:accessMode": "vpn"},
+		"no allow-list": {"bastion.go:accessMode": "ssh-cidrs"},
+		"open to world": {"bastion.go:accessMode": "ssh-cidrs", "bastion.go:sshCidrs": `["0.0.0.0/0"]`},
+		"unused cidrs":  {"bastion.go:accessMode": "ssm-only", "bastion.go:sshCidrs": `["203.0.113.0/24"]`},
+	} {
+		config["bastion.go:tailScaleHostKey"] = "tskey-0123456789"
+
+		m := newMocks()
+		if err := m.Run("bastion.go", createStack, mocks.Config(config)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSessionLogging(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("bastion.go", createStack, config); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("aws:s3/bucket:Bucket", "session-logs"); !ok {
+		t.Error("session log bucket was not created")
+	}
+	if _, ok := m.Resource("aws:cloudwatch/logGroup:LogGroup", "session-logs"); !ok {
+		t.Error("session log group was not created")
+	}
+	if _, ok := m.Resource("aws:iam/rolePolicy:RolePolicy", "session-logs"); !ok {
+		t.Error("bastion should be allowed to write session logs")
+	}
+	if _, ok := m.Resource("aws:ssm/document:Document", "session-manager-preferences"); ok {
+		t.Error("the account's Session Manager preferences should only be managed when configured")
+	}
+}
+
+func TestManageSessionPreferences(t *testing.T) {
+	m := newMocks().
+		SetResourceOutputs("aws:s3/bucket:Bucket", map[string]interface{}{"arn": "arn:aws:s3:::session-logs"}).
+		SetResourceOutputs("aws:cloudwatch/logGroup:LogGroup", map[string]interface{}{"name": "session-logs"})
+	err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+		"bastion.go:tailScaleHostKey":         "tskey-0123456789",
+		"bastion.go:manageSessionPreferences": "true",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, ok := m.Resource("aws:ssm/document:Document", "session-manager-preferences")
+	if !ok {
+		t.Fatal("Session Manager preferences document was not created")
+	}
+	if name := document.Inputs["name"].StringValue(); name != sessionPreferencesDocument {
+		t.Errorf("preferences document name = %s, want %s", name, sessionPreferencesDocument)
+	}
+	if document.ID != "" {
+		t.Errorf("preferences document should only be imported when adopted, got ID %s", document.ID)
+	}
+
+	var preferences struct {
+		Inputs struct {
+			S3BucketName               string `json:"s3BucketName"`
+			CloudWatchLogGroupName     string `json:"cloudWatchLogGroupName"`
+			CloudWatchStreamingEnabled bool   `json:"cloudWatchStreamingEnabled"`
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal([]byte(document.Inputs["content"].StringValue()), &preferences); err != nil {
+		t.Fatal(err)
+	}
+	if preferences.Inputs.S3BucketName == "" || preferences.Inputs.CloudWatchLogGroupName != "session-logs" {
+		t.Errorf("sessions should be logged to S3 and CloudWatch, got %+v", preferences.Inputs)
+	}
+}
+
+func TestAdoptSessionPreferences(t *testing.T) {
+	m := newMocks()
+	err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+		"bastion.go:tailScaleHostKey":         "tskey-0123456789",
+		"bastion.go:manageSessionPreferences": "true",
+		"bastion.go:adoptSessionPreferences":  "true",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, ok := m.Resource("aws:ssm/document:Document", "session-manager-preferences")
+	if !ok {
+		t.Fatal("Session Manager preferences document was not adopted")
+	}
+	if document.ID != sessionPreferencesDocument {
+		t.Errorf("preferences document should be imported as %s, got ID %q", sessionPreferencesDocument, document.ID)
+	}
+
+	err = newMocks().Run("bastion.go", createStack, mocks.Config(map[string]string{
+		"bastion.go:tailScaleHostKey":        "tskey-0123456789",
+		"bastion.go:adoptSessionPreferences": "true",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "bastion.go:manageSessionPreferences") {
+		t.Errorf("adopting the preferences without managing them should fail, got %v", err)
+	}
+}
+
+func TestRouters(t *testing.T) {
+	m := newMocks()
+	err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+		"bastion.go:tailScaleHostKey": "tskey-0123456789",
+		"bastion.go:routers":          `{"count": 3, "healthCheckGracePeriod": 600}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnets := map[string]bool{}
+	for _, name := range []string{"bastion", "bastion-1", "bastion-2"} {
+		asg, ok := m.Resource("aws:autoscaling/group:Group", name)
+		if !ok {
+			t.Errorf("router %s was not created", name)
+			continue
+		}
+		if period := asg.Inputs["healthCheckGracePeriod"].NumberValue(); period != 600 {
+			t.Errorf("router %s health check grace period = %v, want 600", name, period)
+		}
+		preferences := asg.Inputs["instanceRefresh"].ObjectValue()["preferences"].ObjectValue()
+		if warmup := preferences["instanceWarmup"].StringValue(); warmup != "600" {
+			t.Errorf("router %s instance warmup = %s, want the grace period", name, warmup)
+		}
+
+		if version := asg.Inputs["launchTemplate"].ObjectValue()["version"].StringValue(); version != "3" {
+			t.Errorf("router %s should move to the template's latest version, got %s", name, version)
+		}
+
+		ids := asg.Inputs["vpcZoneIdentifiers"].ArrayValue()
+		if len(ids) != 1 {
+			t.Errorf("router %s should be pinned to one subnet, got %v", name, ids)
+			continue
+		}
+		subnets[ids[0].StringValue()] = true
+	}
+	if len(subnets) != 3 {
+		t.Errorf("routers should be spread over the 3 private subnets, got %v", subnets)
+	}
+
+	if n := len(m.Resources("aws:autoscaling/group:Group")); n != 3 {
+		t.Errorf("expected 3 routers, got %d", n)
+	}
+
+	for name, routers := range map[string]string{
+		"negative count":        `{"count": -1}`,
+		"negative grace period": `{"healthCheckGracePeriod": -1}`,
+	} {
+		m := newMocks()
+		err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+			"bastion.go:tailScaleHostKey": "tskey-0123456789",
+			"bastion.go:routers":          routers,
+		}))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRouterRefreshWaits(t *testing.T) {
+	timeout, interval := routerWaitTimeout, routerPollInterval
+	routerWaitTimeout, routerPollInterval = 0, 0
+	t.Cleanup(func() { routerWaitTimeout, routerPollInterval = timeout, interval })
+
+	// the first router never runs the new version, so the second isn't refreshed
+	m := newMocks().SetCallResult("aws:ec2/getInstances:getInstances", map[string]interface{}{
+		"ids": []interface{}{},
+	})
+	err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+		"bastion.go:tailScaleHostKey": "tskey-0123456789",
+		"bastion.go:routers":          `{"count": 2}`,
+	}))
+	if err == nil || !strings.Contains(err.Error(), "isn't running launch template version 3") {
+		t.Errorf("expected the second router to wait for the first, got %v", err)
+	}
+}
+
+func TestRoutersWithoutSubnets(t *testing.T) {
+	for _, count := range []int{1, 3} {
+		m := newMocks().SetStackOutputs("jaxxstorm/vpc.go/"+mocks.Stack, map[string]interface{}{
+			"vpc": map[string]interface{}{
+				"schemaVersion":        2,
+				"id":                   "vpc-0123456789abcdef0",
+				"arn":                  "arn:aws:ec2:us-west-2:123456789012:vpc/vpc-0123456789abcdef0",
+				"cidr":                 "172.1.0.0/16",
+				"publicSubnets":        []interface{}{},
+				"privateSubnets":       []interface{}{},
+				"publicSubnetsByZone":  map[string]interface{}{},
+				"privateSubnetsByZone": map[string]interface{}{},
+				"routeTableIds":        []interface{}{},
+				"natGatewayIps":        []interface{}{},
+				"endpoints":            map[string]interface{}{},
+			},
+		})
+		err := m.Run("bastion.go", createStack, mocks.Config(map[string]string{
+			"bastion.go:tailScaleHostKey": "tskey-0123456789",
+			"bastion.go:routers":          fmt.Sprintf(`{"count": %d}`, count),
+		}))
+		if err == nil || !strings.Contains(err.Error(), errNoSubnets.Error()) {
+			t.Errorf("%d routers: expected a no subnets error, got %v", count, err)
+		}
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("bastion.go", createStack, config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+
+	asg, _ := m.Resource("aws:autoscaling/group:Group", "bastion")
+	if name := asg.Tags()["Name"]; name != "lbriggs-bastion" {
+		t.Errorf("autoscaling group should keep its Name tag, got %q", name)
+	}
+}
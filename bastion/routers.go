@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// nameTag is the Name tag of every bastion, which also scopes the
+// instances' permission to report their own health
+const nameTag = "lbriggs-bastion"
+
+// routerConfig is the bastion.go:routers config object
+type routerConfig struct {
+	// Count is the number of subnet routers, each in its own ASG of one
+	// instance, spread over the private subnets. Set it to the number of
+	// zones for a router in every zone
+	Count int `json:"count"`
+
+	// HealthCheckGracePeriod is how many seconds a new router has to join
+	// the tailnet before its health is checked
+	HealthCheckGracePeriod int `json:"healthCheckGracePeriod"`
+}
+
+// validate checks the config and fills in the defaults
+func (c *routerConfig) validate() error {
+	if c.Count == 0 {
+		c.Count = 1
+	}
+	if c.Count < 0 {
+		return fmt.Errorf("router count must be positive, got %d", c.Count)
+	}
+
+	if c.HealthCheckGracePeriod == 0 {
+		c.HealthCheckGracePeriod = 300
+	}
+	if c.HealthCheckGracePeriod < 0 {
+		return fmt.Errorf("health check grace period must be positive, got %d seconds", c.HealthCheckGracePeriod)
+	}
+
+	return nil
+}
+
+// errNoSubnets is returned when there are no private subnets to run routers in
+var errNoSubnets = errors.New("the vpc stack exports no private subnets to run the routers in")
+
+// routerWaitTimeout and routerPollInterval are how long a router's update
+// waits for the router before it to run the new template version, and how
+// often it checks
+var (
+	routerWaitTimeout  = 15 * time.Minute
+	routerPollInterval = 15 * time.Second
+)
+
+// newRouters creates an ASG for each subnet router. A single router can run
+// in any private subnet, and with more than one, each is pinned to a subnet
+// so they're spread over the zones. Tailscale fails over between routers
+// advertising the same route, so a router only moves to a new template
+// version once the router before it is running it
+func newRouters(ctx *pulumi.Context, template *ec2.LaunchTemplate, subnetIDs pulumi.StringArrayOutput, conf routerConfig) ([]*autoscaling.Group, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	var routers []*autoscaling.Group
+	var previous *autoscaling.Group
+	for i := 0; i < conf.Count; i++ {
+		// the first router keeps the name it had before there could be more
+		name := "bastion"
+		if i > 0 {
+			name = fmt.Sprintf("bastion-%d", i)
+		}
+
+		index, count := i, conf.Count
+		subnets := subnetIDs.ApplyT(func(ids []string) ([]string, error) {
+			if len(ids) == 0 {
+				return nil, errNoSubnets
+			}
+			if count == 1 {
+				return ids, nil
+			}
+			return []string{ids[index%len(ids)]}, nil
+		}).(pulumi.StringArrayOutput)
+
+		/*
+		 * Roll the ASG's instances with an instance
+		 * refresh whenever the template gets a new version
+		 */
+		version := pulumi.Sprintf("%d", template.LatestVersion)
+		opts := routerOptions(ctx, i, template)
+		if previous != nil {
+			version = waitForRouter(ctx, previous, template)
+			opts = append(opts, pulumi.DependsOn([]pulumi.Resource{previous}))
+		}
+
+		// a group of one instance always terminates it before launching its
+		// replacement, whatever the minimum healthy percentage, so a refresh
+		// takes the router offline and the routers are refreshed in turn
+		router, err := autoscaling.NewGroup(ctx, name, &autoscaling.GroupArgs{
+			LaunchTemplate: &autoscaling.GroupLaunchTemplateArgs{
+				Id:      template.ID(),
+				Version: version,
+			},
+			MaxSize:                pulumi.Int(1),
+			MinSize:                pulumi.Int(1),
+			HealthCheckType:        pulumi.String("EC2"),
+			HealthCheckGracePeriod: pulumi.Int(conf.HealthCheckGracePeriod),
+			WaitForCapacityTimeout: pulumi.String("10m"),
+			VpcZoneIdentifiers:     subnets,
+			InstanceRefresh: &autoscaling.GroupInstanceRefreshArgs{
+				Strategy: pulumi.String("Rolling"),
+				Preferences: &autoscaling.GroupInstanceRefreshPreferencesArgs{
+					InstanceWarmup: pulumi.Sprintf("%d", conf.HealthCheckGracePeriod),
+				},
+			},
+			Tags: &autoscaling.GroupTagArray{
+				&autoscaling.GroupTagArgs{
+					Key:               pulumi.String("Name"),
+					Value:             pulumi.String(nameTag),
+					PropagateAtLaunch: pulumi.Bool(true),
+				},
+			},
+		}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		routers = append(routers, router)
+		previous = router
+	}
+
+	return routers, nil
+}
+
+// routerOptions parents a router to the launch template. The first router
+// was created under the launch configuration the bastion used before, so
+// it's aliased to its old URN rather than replaced
+func routerOptions(ctx *pulumi.Context, index int, template *ec2.LaunchTemplate) []pulumi.ResourceOption {
+	opts := []pulumi.ResourceOption{pulumi.Parent(template)}
+	if index == 0 {
+		launchConfiguration := pulumi.URN(fmt.Sprintf("urn:pulumi:%s::%s::aws:ec2/launchConfiguration:LaunchConfiguration::bastion",
+			ctx.Stack(), ctx.Project()))
+		opts = append(opts, pulumi.Aliases([]pulumi.Alias{{ParentURN: launchConfiguration}}))
+	}
+	return opts
+}
+
+// waitForRouter returns the template's latest version once the router is
+// running an instance launched from it, so the next router is only refreshed
+// when the one before it is back. Previews don't wait
+func waitForRouter(ctx *pulumi.Context, router *autoscaling.Group, template *ec2.LaunchTemplate) pulumi.StringOutput {
+	return pulumi.All(router.Name, template.LatestVersion).ApplyT(func(args []interface{}) (string, error) {
+		name, version := args[0].(string), fmt.Sprintf("%d", args[1].(int))
+		if ctx.DryRun() {
+			return version, nil
+		}
+
+		deadline := time.Now().Add(routerWaitTimeout)
+		for {
+			instances, err := ec2.GetInstances(ctx, &ec2.GetInstancesArgs{
+				Filters: []ec2.GetInstancesFilter{
+					{Name: "tag:aws:autoscaling:groupName", Values: []string{name}},
+					{Name: "tag:aws:ec2launchtemplate:version", Values: []string{version}},
+					{Name: "instance-state-name", Values: []string{"running"}},
+				},
+			})
+			if err != nil {
+				return "", err
+			}
+			if len(instances.Ids) > 0 {
+				return version, nil
+			}
+			if time.Now().After(deadline) {
+				return "", fmt.Errorf("router %s isn't running launch template version %s after %s", name, version, routerWaitTimeout)
+			}
+			time.Sleep(routerPollInterval)
+		}
+	}).(pulumi.StringOutput)
+}
@@ -0,0 +1,139 @@
+// Package tailscale creates and revokes auth keys with the Tailscale API, so
+// the bastion's key can be rotated
+package tailscale
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// DefaultBaseURL is the Tailscale API
+const DefaultBaseURL = "https://api.tailscale.com"
+
+// Client calls the Tailscale API for a tailnet
+type Client struct {
+	// BaseURL defaults to DefaultBaseURL, and is a local stand-in in tests
+	BaseURL string
+
+	// APIKey is an API access token for the tailnet
+	APIKey  string
+	Tailnet string
+
+	HTTPClient *http.Client
+}
+
+// KeyOptions are the capabilities of a new auth key
+type KeyOptions struct {
+	// Reusable keys can register more than one node, e.g. every instance
+	// the bastion's ASG launches
+	Reusable bool
+
+	// Ephemeral nodes are removed from the tailnet once they go offline.
+	// Subnet routers shouldn't be, so their approved routes survive a
+	// restart
+	Ephemeral bool
+
+	// Tags are the ACL tags nodes registered with the key get
+	Tags []string
+
+	// Expiry is how long the key can be used for, the API's default if 0
+	Expiry time.Duration
+}
+
+// Key is an auth key. The secret Key is only set when it's created
+type Key struct {
+	ID      string    `json:"id"`
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+type createKeyRequest struct {
+	Capabilities struct {
+		Devices struct {
+			Create struct {
+				Reusable      bool     `json:"reusable"`
+				Ephemeral     bool     `json:"ephemeral"`
+				Preauthorized bool     `json:"preauthorized"`
+				Tags          []string `json:"tags,omitempty"`
+			} `json:"create"`
+		} `json:"devices"`
+	} `json:"capabilities"`
+	ExpirySeconds int64 `json:"expirySeconds,omitempty"`
+}
+
+// CreateKey creates a pre-authorized auth key
+func (c *Client) CreateKey(options KeyOptions) (*Key, error) {
+	var req createKeyRequest
+	create := &req.Capabilities.Devices.Create
+	create.Reusable = options.Reusable
+	create.Ephemeral = options.Ephemeral
+	create.Preauthorized = true
+	create.Tags = options.Tags
+	req.ExpirySeconds = int64(options.Expiry / time.Second)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var key Key
+	if err := c.do(http.MethodPost, "keys", body, &key); err != nil {
+		return nil, fmt.Errorf("creating key: %w", err)
+	}
+	return &key, nil
+}
+
+// DeleteKey revokes the auth key with the given ID. Nodes already registered
+// with it stay in the tailnet, but instances launched with it can't join, so
+// a replaced key is only revoked once every router runs with the new one
+func (c *Client) DeleteKey(id string) error {
+	if err := c.do(http.MethodDelete, "keys/"+id, nil, nil); err != nil {
+		return fmt.Errorf("deleting key %s: %w", id, err)
+	}
+	return nil
+}
+
+// do calls the tailnet's API at path, decoding the response into result
+func (c *Client) do(method, path string, body []byte, result interface{}) error {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/api/v2/tailnet/%s/%s", baseURL, c.Tailnet, path), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.APIKey, "")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
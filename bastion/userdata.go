@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"text/template"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// userDataValues are the inputs to the userdata template
+type userDataValues struct {
+	// Cidr is the route the bastion advertises to the tailnet
+	Cidr string
+
+	// Region and ParameterName locate the Tailscale auth key in SSM
+	Region        string
+	ParameterName string
+
+	// Hostname and Tags identify the bastion in the tailnet. With more
+	// than one router, the hostname ends with the router's zone
+	Hostname     string
+	ZoneHostname bool
+	Tags         []string
+
+	// KeyVersion changes with the auth key, so rotating the key changes
+	// the launch template and replaces the bastion
+	KeyVersion string
+}
+
+// keyVersion identifies an auth key without revealing it
+func keyVersion(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// renderUserData renders the userdata template with the given values
+func renderUserData(text string, values userDataValues) (string, error) {
+	tmpl, err := template.New("userdata").
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// userData renders the userdata template once the stack's values are known,
+// and base64 encodes it for the launch template. The key is a secret output,
+// so only its version is rendered
+func userData(text string, cidr, parameterName, key pulumi.StringOutput, values userDataValues) pulumi.StringOutput {
+	return pulumi.All(cidr, parameterName, key).ApplyT(func(args []interface{}) (string, error) {
+		values.Cidr = args[0].(string)
+		values.ParameterName = args[1].(string)
+		values.KeyVersion = keyVersion(args[2].(string))
+
+		rendered, err := renderUserData(text, values)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(rendered)), nil
+	}).(pulumi.StringOutput)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestRenderUserData(t *testing.T) {
+	text, err := ioutil.ReadFile("userdata.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values userDataValues
+	}{
+		{
+			name: "userdata",
+			values: userDataValues{
+				Cidr:          "172.1.0.0/16",
+				Region:        "us-west-2",
+				ParameterName: "tailscale-auth-key",
+				Hostname:      "lbriggs-bastion",
+				KeyVersion:    keyVersion("tskey-0123456789"),
+			},
+		},
+		{
+			name: "userdata-tags",
+			values: userDataValues{
+				Cidr:          "10.0.0.0/16",
+				Region:        "eu-west-1",
+				ParameterName: "bastion-auth-key",
+				Hostname:      "bastion-eu",
+				Tags:          []string{"tag:bastion", "tag:subnet-router"},
+				KeyVersion:    keyVersion("tskey-9876543210"),
+			},
+		},
+		{
+			name: "userdata-routers",
+			values: userDataValues{
+				Cidr:          "172.1.0.0/16",
+				Region:        "us-west-2",
+				ParameterName: "tailscale-auth-key",
+				Hostname:      "lbriggs-bastion",
+				ZoneHostname:  true,
+				KeyVersion:    keyVersion("tskey-0123456789"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderUserData(string(text), tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("userdata does not match %s, run go test -update\ngot:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestKeyVersion(t *testing.T) {
+	if keyVersion("tskey-0123456789") == keyVersion("tskey-9876543210") {
+		t.Error("different keys should have different versions")
+	}
+	if v := keyVersion("tskey-0123456789"); strings.Contains(v, "0123456789") || len(v) != 12 {
+		t.Errorf("key version %q should be a short hash of the key", v)
+	}
+}
@@ -0,0 +1,11 @@
+module cluster-autoscaler.go
+
+go 1.14
+
+require (
+	github.com/jaxxstorm/iac-in-go/lib v0.0.0
+	github.com/pulumi/pulumi-kubernetes/sdk/v2 v2.4.0
+	github.com/pulumi/pulumi/sdk/v2 v2.6.1
+)
+
+replace github.com/jaxxstorm/iac-in-go/lib => ../lib
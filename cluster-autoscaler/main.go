@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jaxxstorm/iac-in-go/lib/awsprovider"
+	"github.com/jaxxstorm/iac-in-go/lib/irsa"
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/jaxxstorm/iac-in-go/lib/tags"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/helm/v2"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/providers"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+const (
+	// chartVersion is the version of the cluster-autoscaler chart
+	chartVersion = "9.9.2"
+
+	// serviceAccount is the service account the autoscaler runs as
+	serviceAccount = "cluster-autoscaler"
+)
+
+// imageTags is the autoscaler release for each Kubernetes minor version. The
+// autoscaler is only tested against the minor version it's released with
+var imageTags = map[string]string{
+	"1.17": "v1.17.4",
+	"1.18": "v1.18.3",
+	"1.19": "v1.19.1",
+	"1.20": "v1.20.0",
+}
+
+// imageTag returns the autoscaler image tag for a cluster's Kubernetes version
+func imageTag(kubernetesVersion string) (string, error) {
+	tag, ok := imageTags[kubernetesVersion]
+	if !ok {
+		versions := make([]string, 0, len(imageTags))
+		for v := range imageTags {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return "", fmt.Errorf("no cluster-autoscaler release is pinned for Kubernetes %s, add one for %v", kubernetesVersion, versions)
+	}
+	return tag, nil
+}
+
+func main() {
+	pulumi.Run(createStack)
+}
+
+func createStack(ctx *pulumi.Context) error {
+	/*
+	 * Tag every AWS resource in the stack
+	 */
+	if err := tags.Register(ctx); err != nil {
+		return err
+	}
+
+	/*
+	 * Look up the account and region
+	 * the stack deploys to
+	 */
+	account, err := awsprovider.Lookup(ctx)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Grab the EKS stack outputs
+	 */
+	cluster, err := stackref.Eks(ctx)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Create an IAM role only the autoscaler's
+	 * service account can assume, which can only
+	 * scale the ASGs EKS tagged for the cluster
+	 */
+	autoscalerIAMRole, err := irsa.NewServiceAccountRole(ctx, "cluster-autoscaler", "kube-system", serviceAccount, irsa.Policies{
+		Inline: map[string]policy.Document{
+			"autoscaling": {
+				Statement: []policy.Statement{
+					{
+						Effect: policy.Allow,
+						Action: []string{
+							"autoscaling:SetDesiredCapacity",
+							"autoscaling:TerminateInstanceInAutoScalingGroup",
+						},
+						Resource: policy.Strings("*"),
+						Condition: []policy.Condition{
+							{
+								Test:     "StringEquals",
+								Variable: pulumi.Sprintf("aws:ResourceTag/k8s.io/cluster-autoscaler/%s", cluster.ClusterName),
+								Values:   policy.Strings("owned"),
+							},
+						},
+					},
+					{
+						Effect: policy.Allow,
+						Action: []string{
+							"autoscaling:DescribeAutoScalingGroups",
+							"autoscaling:DescribeAutoScalingInstances",
+							"autoscaling:DescribeLaunchConfigurations",
+							"autoscaling:DescribeTags",
+							"ec2:DescribeLaunchTemplateVersions",
+							"ec2:DescribeInstanceTypes",
+						},
+						Resource: policy.Strings("*"),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Install the autoscaler via helm chart, finding
+	 * the node groups' ASGs by the tags EKS adds,
+	 * with the release for the cluster's version
+	 */
+	provider, err := providers.NewProvider(ctx, "k8sprovider", &providers.ProviderArgs{
+		Kubeconfig:                  cluster.Kubeconfig,
+		SuppressDeprecationWarnings: pulumi.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = helm.NewChart(ctx, "cluster-autoscaler", helm.ChartArgs{
+		Chart:   pulumi.String("cluster-autoscaler"),
+		Version: pulumi.String(chartVersion),
+		FetchArgs: &helm.FetchArgs{
+			Repo: pulumi.String("https://kubernetes.github.io/autoscaler"),
+		},
+		Values: pulumi.Map{
+			"awsRegion": pulumi.String(account.Region),
+			"image": pulumi.Map{
+				"tag": cluster.KubernetesVersion.ApplyT(imageTag).(pulumi.StringOutput),
+			},
+			"autoDiscovery": pulumi.Map{
+				"clusterName": cluster.ClusterName,
+			},
+			"rbac": pulumi.Map{
+				"serviceAccount": pulumi.Map{
+					"name": pulumi.String(serviceAccount),
+					"annotations": pulumi.Map{
+						irsa.RoleArnAnnotation: autoscalerIAMRole.Arn,
+					},
+				},
+			},
+			"extraArgs": pulumi.Map{
+				"balance-similar-node-groups": pulumi.Bool(true),
+				"skip-nodes-with-system-pods": pulumi.Bool(false),
+			},
+		},
+		Namespace: pulumi.String("kube-system"),
+	}, pulumi.Provider(provider))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestServiceAccountRole(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("cluster-autoscaler.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("aws:iam/role:Role", "cluster-autoscaler"); !ok {
+		t.Fatal("cluster-autoscaler role was not created")
+	}
+
+	rolePolicy, ok := m.Resource("aws:iam/rolePolicy:RolePolicy", "cluster-autoscaler-autoscaling")
+	if !ok {
+		t.Fatal("cluster-autoscaler policy was not created")
+	}
+
+	var policy struct {
+		Statement []struct {
+			Action    []string
+			Condition map[string]map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(rolePolicy.Inputs["policy"].StringValue()), &policy); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, statement := range policy.Statement {
+		for _, action := range statement.Action {
+			if action != "autoscaling:SetDesiredCapacity" && action != "autoscaling:TerminateInstanceInAutoScalingGroup" {
+				continue
+			}
+			if tag := statement.Condition["StringEquals"]["aws:ResourceTag/k8s.io/cluster-autoscaler/lbriggs"]; tag != "owned" {
+				t.Errorf("%s should be scoped to the cluster's ASGs, got %v", action, statement.Condition)
+			}
+		}
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	tag, err := imageTag("1.18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.18.3" {
+		t.Errorf("imageTag(1.18) = %s, want v1.18.3", tag)
+	}
+
+	if _, err := imageTag("1.12"); err == nil {
+		t.Error("expected an error for a Kubernetes version without a pinned release")
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("cluster-autoscaler.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestDatabaseNotPublic(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("db.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	sg, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "rds-db-security-group")
+	if !ok {
+		t.Fatal("database security group was not created")
+	}
+	vpcAllowed := false
+	for _, rule := range sg.Ingress() {
+		if rule.Allows("0.0.0.0/0", 3306) {
+			t.Errorf("database security group opens 3306 to the world: %+v", rule)
+		}
+		vpcAllowed = vpcAllowed || rule.Allows("172.1.0.0/16", 3306)
+	}
+	if !vpcAllowed {
+		t.Error("database security group should allow 3306 from the VPC")
+	}
+
+	subnetGroup, ok := m.Resource("aws:rds/subnetGroup:SubnetGroup", "db-subnet-group")
+	if !ok {
+		t.Fatal("database subnet group was not created")
+	}
+	for _, subnet := range subnetGroup.Inputs["subnetIds"].ArrayValue() {
+		if !strings.HasPrefix(subnet.StringValue(), "subnet-private") {
+			t.Errorf("database subnet group uses non-private subnet %s", subnet.StringValue())
+		}
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("db.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestTaskExecutionRole(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("ecs.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	role, ok := m.Resource("aws:iam/role:Role", "task-exec-role")
+	if !ok {
+		t.Fatal("task execution role was not created")
+	}
+
+	var policy struct {
+		Statement []struct {
+			Action    string
+			Principal struct{ Service string }
+		}
+	}
+	if err := json.Unmarshal([]byte(role.Inputs["assumeRolePolicy"].StringValue()), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 1 || policy.Statement[0].Principal.Service != "ecs-tasks.amazonaws.com" {
+		t.Errorf("task execution role should only be assumable by ECS tasks, got %+v", policy.Statement)
+	}
+
+	attachment, ok := m.Resource("aws:iam/rolePolicyAttachment:RolePolicyAttachment", "task-exec-policy")
+	if !ok {
+		t.Fatal("task execution policy was not attached")
+	}
+	if arn := attachment.Inputs["policyArn"].StringValue(); arn != "arn:aws:iam::aws:policy/service-role/AmazonECSTaskExecutionRolePolicy" {
+		t.Errorf("unexpected task execution policy %s", arn)
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("ecs.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}
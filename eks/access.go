@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/meta/v1"
+	rbacv1 "github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/rbac/v1"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// readOnlyGroup is the Kubernetes group bound to the view cluster role
+	readOnlyGroup = "read-only"
+
+	// adminGroup is the Kubernetes group bound to the cluster-admin cluster role
+	adminGroup = "admin"
+
+	// awsAuthID is the ID of the aws-auth ConfigMap
+	awsAuthID = "kube-system/aws-auth"
+
+	// awsAuthManagedOutput is the output which records that the stack
+	// manages aws-auth
+	awsAuthManagedOutput = "awsAuthManaged"
+)
+
+// accessMapping is an entry in the eks.go:access config list, which maps an
+// IAM role or user to Kubernetes groups
+type accessMapping struct {
+	Arn      string   `json:"arn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// awsAuthRole and awsAuthUser are entries in the aws-auth ConfigMap
+type awsAuthRole struct {
+	RoleArn  string   `yaml:"rolearn"`
+	Username string   `yaml:"username"`
+	Groups   []string `yaml:"groups"`
+}
+
+type awsAuthUser struct {
+	UserArn  string   `yaml:"userarn"`
+	Username string   `yaml:"username"`
+	Groups   []string `yaml:"groups"`
+}
+
+// principal splits an IAM ARN into its type, role or user, and name
+func (m accessMapping) principal() (string, string, error) {
+	for _, kind := range []string{"role", "user"} {
+		parts := strings.SplitN(m.Arn, fmt.Sprintf(":%s/", kind), 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], "arn:aws:iam::") {
+			path := strings.Split(parts[1], "/")
+			return kind, path[len(path)-1], nil
+		}
+	}
+	return "", "", fmt.Errorf("%q is not an IAM role or user ARN", m.Arn)
+}
+
+// awsAuthData renders the aws-auth ConfigMap, which always maps the node
+// role so nodes can join the cluster
+func awsAuthData(nodeRoleArn string, mappings []accessMapping) (map[string]string, error) {
+	roles := []awsAuthRole{
+		{
+			RoleArn:  nodeRoleArn,
+			Username: "system:node:{{EC2PrivateDNSName}}",
+			Groups:   []string{"system:bootstrappers", "system:nodes"},
+		},
+	}
+	users := []awsAuthUser{}
+
+	for _, m := range mappings {
+		kind, name, err := m.principal()
+		if err != nil {
+			return nil, err
+		}
+		if len(m.Groups) == 0 {
+			return nil, fmt.Errorf("%s has no groups", m.Arn)
+		}
+
+		username := m.Username
+		if username == "" {
+			username = name
+		}
+
+		// aws-auth doesn't match role ARNs with a path
+		arn := m.Arn
+		if kind == "role" {
+			arn = fmt.Sprintf("%s:role/%s", strings.SplitN(m.Arn, ":role/", 2)[0], name)
+			roles = append(roles, awsAuthRole{RoleArn: arn, Username: username, Groups: m.Groups})
+		} else {
+			users = append(users, awsAuthUser{UserArn: arn, Username: username, Groups: m.Groups})
+		}
+	}
+
+	mapRoles, err := yaml.Marshal(roles)
+	if err != nil {
+		return nil, err
+	}
+	mapUsers, err := yaml.Marshal(users)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"mapRoles": string(mapRoles),
+		"mapUsers": string(mapUsers),
+	}, nil
+}
+
+// clusterExists reports whether the named cluster has been created. EKS
+// creates aws-auth when the first node group joins, so an existing cluster
+// already has one, which may not be managed by the stack yet
+func clusterExists(ctx *pulumi.Context, name string) (bool, error) {
+	_, err := eks.LookupCluster(ctx, &eks.LookupClusterArgs{Name: name})
+	switch {
+	case err == nil:
+		return true, nil
+	case strings.Contains(err.Error(), "ResourceNotFoundException"):
+		return false, nil
+	default:
+		return false, fmt.Errorf("error looking up cluster %s: %w", name, err)
+	}
+}
+
+// newAccess manages the aws-auth ConfigMap, and binds the read-only and
+// admin groups to cluster roles. Pass the cluster's Kubernetes provider in opts.
+//
+// On an existing cluster the stack may not manage aws-auth yet, if EKS created
+// it before the stack did. The stack's last outputs say whether it does, and
+// if not the live ConfigMap is imported with the data it already has. The
+// mappings are then applied on the next update
+func newAccess(ctx *pulumi.Context, nodeRoleArn pulumi.StringOutput, mappings []accessMapping, existing bool,
+	opts ...pulumi.ResourceOption) (*corev1.ConfigMap, error) {
+	// check the mappings before anything is created
+	if _, err := awsAuthData("", mappings); err != nil {
+		return nil, err
+	}
+
+	data := nodeRoleArn.ApplyT(func(arn string) (map[string]string, error) {
+		return awsAuthData(arn, mappings)
+	}).(pulumi.StringMapOutput)
+
+	awsAuthOpts := opts
+	if existing {
+		self, err := stackref.Self(ctx)
+		if err != nil {
+			return nil, err
+		}
+		adopt := self.GetBool(awsAuthManagedOutput).ApplyT(func(managed bool) bool {
+			return !managed
+		}).(pulumi.BoolOutput)
+
+		/*
+		 * An import fails if the inputs differ
+		 * from the live ConfigMap, so read it and
+		 * import it unchanged
+		 */
+		live, err := corev1.GetConfigMap(ctx, "aws-auth-existing", pulumi.ID(awsAuthID), nil, opts...)
+		if err != nil {
+			return nil, err
+		}
+		data = pulumi.All(adopt, live.Data, data).ApplyT(func(args []interface{}) map[string]string {
+			if args[0].(bool) {
+				return args[1].(map[string]string)
+			}
+			return args[2].(map[string]string)
+		}).(pulumi.StringMapOutput)
+
+		// an empty ID doesn't import anything
+		importID := adopt.ApplyT(func(adopt bool) pulumi.ID {
+			if adopt {
+				return awsAuthID
+			}
+			return ""
+		}).(pulumi.IDOutput)
+		awsAuthOpts = append([]pulumi.ResourceOption{pulumi.Import(importID)}, opts...)
+	}
+
+	awsAuth, err := corev1.NewConfigMap(ctx, "aws-auth", &corev1.ConfigMapArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String("aws-auth"),
+			Namespace: pulumi.String("kube-system"),
+		},
+		Data: data,
+	}, awsAuthOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for group, clusterRole := range map[string]string{
+		readOnlyGroup: "view",
+		adminGroup:    "cluster-admin",
+	} {
+		_, err := rbacv1.NewClusterRoleBinding(ctx, group, &rbacv1.ClusterRoleBindingArgs{
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: pulumi.String(group),
+			},
+			RoleRef: &rbacv1.RoleRefArgs{
+				ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+				Kind:     pulumi.String("ClusterRole"),
+				Name:     pulumi.String(clusterRole),
+			},
+			Subjects: rbacv1.SubjectArray{
+				&rbacv1.SubjectArgs{
+					ApiGroup: pulumi.String("rbac.authorization.k8s.io"),
+					Kind:     pulumi.String("Group"),
+					Name:     pulumi.String(group),
+				},
+			},
+		}, opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return awsAuth, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestAwsAuthData(t *testing.T) {
+	data, err := awsAuthData("arn:aws:iam::123456789012:role/nodes", []accessMapping{
+		{Arn: "arn:aws:iam::123456789012:role/teams/platform", Groups: []string{adminGroup}},
+		{Arn: "arn:aws:iam::123456789012:user/auditor", Username: "audit", Groups: []string{readOnlyGroup}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var roles []awsAuthRole
+	if err := yaml.Unmarshal([]byte(data["mapRoles"]), &roles); err != nil {
+		t.Fatal(err)
+	}
+	wantRoles := []awsAuthRole{
+		{RoleArn: "arn:aws:iam::123456789012:role/nodes", Username: "system:node:{{EC2PrivateDNSName}}", Groups: []string{"system:bootstrappers", "system:nodes"}},
+		{RoleArn: "arn:aws:iam::123456789012:role/platform", Username: "platform", Groups: []string{adminGroup}},
+	}
+	if !reflect.DeepEqual(roles, wantRoles) {
+		t.Errorf("mapRoles = %+v, want %+v", roles, wantRoles)
+	}
+
+	var users []awsAuthUser
+	if err := yaml.Unmarshal([]byte(data["mapUsers"]), &users); err != nil {
+		t.Fatal(err)
+	}
+	wantUsers := []awsAuthUser{
+		{UserArn: "arn:aws:iam::123456789012:user/auditor", Username: "audit", Groups: []string{readOnlyGroup}},
+	}
+	if !reflect.DeepEqual(users, wantUsers) {
+		t.Errorf("mapUsers = %+v, want %+v", users, wantUsers)
+	}
+}
+
+func TestAwsAuthDataInvalid(t *testing.T) {
+	for name, mapping := range map[string]accessMapping{
+		"not iam":   {Arn: "arn:aws:s3:::bucket/role/admin", Groups: []string{adminGroup}},
+		"group":     {Arn: "arn:aws:iam::123456789012:group/admins", Groups: []string{adminGroup}},
+		"no groups": {Arn: "arn:aws:iam::123456789012:role/admin"},
+	} {
+		if _, err := awsAuthData("arn:aws:iam::123456789012:role/nodes", []accessMapping{mapping}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+const (
+	// ebsCsiAddon is the add-on which runs the EBS CSI driver
+	ebsCsiAddon = "aws-ebs-csi-driver"
+
+	// ebsCsiServiceAccount is the service account the EBS CSI controller runs as
+	ebsCsiServiceAccount = "system:serviceaccount:kube-system:ebs-csi-controller-sa"
+)
+
+// addonConfig pins the version of an EKS add-on, and says what happens to
+// fields changed on the cluster since it was last applied
+type addonConfig struct {
+	Version          string `json:"version"`
+	ResolveConflicts string `json:"resolveConflicts"`
+}
+
+// defaultAddons are the add-ons managed when eks.go:addons isn't set, for
+// each Kubernetes version. kube-proxy and coredns are built for a single
+// Kubernetes minor version, so each version has its own set
+var defaultAddons = map[string]map[string]addonConfig{
+	"1.18": {
+		"vpc-cni":    {Version: "v1.7.5-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		"coredns":    {Version: "v1.7.0-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		"kube-proxy": {Version: "v1.18.8-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		ebsCsiAddon:  {Version: "v1.5.2-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+	},
+	"1.19": {
+		"vpc-cni":    {Version: "v1.7.5-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		"coredns":    {Version: "v1.8.0-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		"kube-proxy": {Version: "v1.19.6-eksbuild.2", ResolveConflicts: "OVERWRITE"},
+		ebsCsiAddon:  {Version: "v1.5.2-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+	},
+	"1.20": {
+		"vpc-cni":    {Version: "v1.7.5-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		"coredns":    {Version: "v1.8.3-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+		"kube-proxy": {Version: "v1.20.4-eksbuild.2", ResolveConflicts: "OVERWRITE"},
+		ebsCsiAddon:  {Version: "v1.5.2-eksbuild.1", ResolveConflicts: "OVERWRITE"},
+	},
+}
+
+// defaultAddonsFor returns the default add-ons for a Kubernetes version
+func defaultAddonsFor(version string) (map[string]addonConfig, error) {
+	addons, ok := defaultAddons[version]
+	if !ok {
+		versions := make([]string, 0, len(defaultAddons))
+		for v := range defaultAddons {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return nil, fmt.Errorf("no default add-on versions for Kubernetes %s, set eks.go:addons or use one of %v", version, versions)
+	}
+	return addons, nil
+}
+
+// validateAddons checks every add-on is pinned to a version, with a conflict
+// resolution EKS understands
+func validateAddons(addons map[string]addonConfig) error {
+	for _, name := range addonNames(addons) {
+		addon := addons[name]
+		if addon.Version == "" {
+			return fmt.Errorf("add-on %q has no version", name)
+		}
+		switch addon.ResolveConflicts {
+		case "NONE", "OVERWRITE":
+		default:
+			return fmt.Errorf("add-on %q has resolveConflicts %q, must be NONE or OVERWRITE", name, addon.ResolveConflicts)
+		}
+	}
+	return nil
+}
+
+// addonNames returns the add-on names in a stable order
+func addonNames(addons map[string]addonConfig) []string {
+	names := make([]string, 0, len(addons))
+	for name := range addons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newAddons manages the cluster's add-ons, and returns the ARN of the role
+// the EBS CSI driver's service account assumes
+func newAddons(ctx *pulumi.Context, cluster *eks.Cluster, oidcProviderArn pulumi.StringInput, addons map[string]addonConfig,
+	opts ...pulumi.ResourceOption) (pulumi.StringOutput, error) {
+	if err := validateAddons(addons); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	ebsCsiRoleArn := pulumi.String("").ToStringOutput()
+	if _, ok := addons[ebsCsiAddon]; ok {
+		var err error
+		ebsCsiRoleArn, err = newEbsCsiRole(ctx, oidcProviderArn)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+
+	for _, name := range addonNames(addons) {
+		addon := addons[name]
+		args := &eks.AddonArgs{
+			ClusterName:      cluster.Name,
+			AddonName:        pulumi.String(name),
+			AddonVersion:     pulumi.String(addon.Version),
+			ResolveConflicts: pulumi.String(addon.ResolveConflicts),
+		}
+		if name == ebsCsiAddon {
+			args.ServiceAccountRoleArn = ebsCsiRoleArn
+		}
+
+		addonOpts := append([]pulumi.ResourceOption{pulumi.Parent(cluster)}, opts...)
+		if _, err := eks.NewAddon(ctx, name, args, addonOpts...); err != nil {
+			return pulumi.StringOutput{}, err
+		}
+	}
+
+	return ebsCsiRoleArn, nil
+}
+
+// newEbsCsiRole creates the role the EBS CSI driver's service account assumes
+func newEbsCsiRole(ctx *pulumi.Context, oidcProviderArn pulumi.StringInput) (pulumi.StringOutput, error) {
+	/*
+	 * The EBS CSI driver creates volumes, so it
+	 * gets its own role through the OIDC provider
+	 */
+	assumeRolePolicyJSON, err := policy.WebIdentityAssumeRole(oidcProviderArn, ebsCsiServiceAccount).Render()
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	role, err := iam.NewRole(ctx, "ebs-csi-driver", &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicyJSON,
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	_, err = iam.NewRolePolicyAttachment(ctx, "ebs-csi-driver", &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String("arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy"),
+	}, pulumi.Parent(role))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	return role.Arn, nil
+}
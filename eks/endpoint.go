@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// endpointConfig is the eks.go:endpoint config, which says where the cluster's
+// API server can be reached from
+type endpointConfig struct {
+	// PublicAccess serves the API on the internet, to PublicAccessCidrs
+	// only, and defaults to true
+	PublicAccess      *bool    `json:"publicAccess"`
+	PublicAccessCidrs []string `json:"publicAccessCidrs"`
+
+	// PrivateAccess serves the API inside the VPC
+	PrivateAccess bool `json:"privateAccess"`
+
+	// Tailscale lets the bastion's subnet router reach the private endpoint
+	Tailscale bool `json:"tailscale"`
+}
+
+func (c endpointConfig) publicAccess() bool {
+	return c.PublicAccess == nil || *c.PublicAccess
+}
+
+// validate checks the API server is reachable from somewhere, and is never
+// open to the whole internet
+func (c endpointConfig) validate() error {
+	if !c.PrivateAccess && !c.publicAccess() {
+		return fmt.Errorf("the cluster endpoint needs private or public access")
+	}
+	if c.Tailscale && !c.PrivateAccess {
+		return fmt.Errorf("tailscale access needs the private endpoint")
+	}
+
+	if !c.publicAccess() {
+		if len(c.PublicAccessCidrs) > 0 {
+			return fmt.Errorf("publicAccessCidrs are set, but public access is disabled")
+		}
+		return nil
+	}
+
+	if len(c.PublicAccessCidrs) == 0 {
+		return fmt.Errorf("public access needs an allow-list, set eks.go:endpoint.publicAccessCidrs")
+	}
+	for _, cidr := range c.PublicAccessCidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("public access CIDR %q: %w", cidr, err)
+		}
+		if ones, _ := network.Mask.Size(); ones == 0 {
+			return fmt.Errorf("public access CIDR %q opens the endpoint to the whole internet", cidr)
+		}
+	}
+	return nil
+}
+
+// vpcConfig returns the cluster's endpoint settings
+func (c endpointConfig) vpcConfig(securityGroupIDs, subnetIDs pulumi.StringArrayInput) *eks.ClusterVpcConfigArgs {
+	args := &eks.ClusterVpcConfigArgs{
+		EndpointPrivateAccess: pulumi.Bool(c.PrivateAccess),
+		EndpointPublicAccess:  pulumi.Bool(c.publicAccess()),
+		SecurityGroupIds:      securityGroupIDs,
+		SubnetIds:             subnetIDs,
+	}
+	if c.publicAccess() {
+		args.PublicAccessCidrs = pulumi.StringArray(policy.Strings(c.PublicAccessCidrs...))
+	}
+	return args
+}
+
+// ingress returns the rules letting clients reach the private endpoint
+func (c endpointConfig) ingress(ctx *pulumi.Context) (ec2.SecurityGroupIngressArray, error) {
+	ingress := ec2.SecurityGroupIngressArray{}
+	if !c.Tailscale {
+		return ingress, nil
+	}
+
+	/*
+	 * The bastion NATs tailnet traffic through its own
+	 * interface, so trust its security group
+	 */
+	bastion, err := stackref.Bastion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ingress = append(ingress, ec2.SecurityGroupIngressArgs{
+		Description:    pulumi.String("Kubernetes API from the Tailscale subnet router"),
+		Protocol:       pulumi.String("tcp"),
+		FromPort:       pulumi.Int(443),
+		ToPort:         pulumi.Int(443),
+		SecurityGroups: pulumi.StringArray{bastion.SecurityGroupID},
+	})
+	return ingress, nil
+}
@@ -3,6 +3,11 @@ module eks.go
 go 1.14
 
 require (
-	github.com/pulumi/pulumi-aws/sdk/v2 v2.0.0
-	github.com/pulumi/pulumi/sdk/v2 v2.0.0
+	github.com/jaxxstorm/iac-in-go/lib v0.0.0
+	github.com/pulumi/pulumi-aws/sdk/v3 v3.38.0
+	github.com/pulumi/pulumi-kubernetes/sdk/v2 v2.4.0
+	github.com/pulumi/pulumi/sdk/v2 v2.25.2
+	gopkg.in/yaml.v2 v2.2.8
 )
+
+replace github.com/jaxxstorm/iac-in-go/lib => ../lib
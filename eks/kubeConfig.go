@@ -2,96 +2,246 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"gopkg.in/yaml.v2"
 )
 
 type KubeConfig struct {
-	APIVersion     string      `json:"apiVersion"`
-	Clusters       []Clusters  `json:"clusters"`
-	Contexts       []Contexts  `json:"contexts"`
-	CurrentContext string      `json:"current-context"`
-	Kind           string      `json:"kind"`
-	Preferences    Preferences `json:"preferences"`
-	Users          []Users     `json:"users"`
+	APIVersion     string      `json:"apiVersion" yaml:"apiVersion"`
+	Clusters       []Clusters  `json:"clusters" yaml:"clusters"`
+	Contexts       []Contexts  `json:"contexts" yaml:"contexts"`
+	CurrentContext string      `json:"current-context" yaml:"current-context"`
+	Kind           string      `json:"kind" yaml:"kind"`
+	Preferences    Preferences `json:"preferences" yaml:"preferences"`
+	Users          []Users     `json:"users" yaml:"users"`
 }
 type Cluster struct {
-	Server                   string `json:"server"`
-	CertificateAuthorityData string `json:"certificate-authority-data"`
+	Server                   string `json:"server" yaml:"server"`
+	CertificateAuthorityData string `json:"certificate-authority-data" yaml:"certificate-authority-data"`
 }
 type Clusters struct {
-	Cluster Cluster `json:"cluster"`
-	Name    string  `json:"name"`
+	Cluster Cluster `json:"cluster" yaml:"cluster"`
+	Name    string  `json:"name" yaml:"name"`
 }
 type Context struct {
-	Cluster string `json:"cluster"`
-	User    string `json:"user"`
+	Cluster   string `json:"cluster" yaml:"cluster"`
+	User      string `json:"user" yaml:"user"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
 }
 type Contexts struct {
-	Context Context `json:"context"`
-	Name    string  `json:"name"`
+	Context Context `json:"context" yaml:"context"`
+	Name    string  `json:"name" yaml:"name"`
 }
 type Preferences struct {
 }
 type Exec struct {
-	APIVersion string   `json:"apiVersion"`
-	Command    string   `json:"command"`
-	Args       []string `json:"args"`
+	APIVersion      string    `json:"apiVersion" yaml:"apiVersion"`
+	Command         string    `json:"command" yaml:"command"`
+	Args            []string  `json:"args" yaml:"args"`
+	Env             []ExecEnv `json:"env,omitempty" yaml:"env,omitempty"`
+	InteractiveMode string    `json:"interactiveMode,omitempty" yaml:"interactiveMode,omitempty"`
+}
+type ExecEnv struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
 }
 type User struct {
-	Exec Exec `json:"exec"`
+	Exec Exec `json:"exec" yaml:"exec"`
 }
 type Users struct {
-	Name string `json:"name"`
-	User User   `json:"user"`
+	Name string `json:"name" yaml:"name"`
+	User User   `json:"user" yaml:"user"`
+}
+
+const (
+	// awsCLI authenticates with aws eks get-token
+	awsCLI = "aws"
+
+	// awsIamAuthenticator authenticates with aws-iam-authenticator token
+	awsIamAuthenticator = "aws-iam-authenticator"
+)
+
+// kubeconfigOptions is the eks.go:kubeconfig config object, which sets how
+// kubectl authenticates with the cluster
+type kubeconfigOptions struct {
+	// APIVersion is the exec credential API version, v1beta1 or v1
+	APIVersion string `json:"apiVersion"`
+
+	// Authenticator is the command which gets a token, aws or aws-iam-authenticator
+	Authenticator string `json:"authenticator"`
+
+	// RoleArn is an optional role to assume when getting a token
+	RoleArn string `json:"roleArn"`
+
+	// Profile is an optional AWS profile, set as AWS_PROFILE
+	Profile string `json:"profile"`
+
+	// Namespaces adds a context for each namespace in every cluster
+	Namespaces []string `json:"namespaces"`
+
+	// Region is the region of the cluster, passed to aws eks get-token
+	Region string `json:"-"`
+}
+
+// validate checks the options and fills in the defaults
+func (o *kubeconfigOptions) validate() error {
+	switch o.APIVersion {
+	case "":
+		o.APIVersion = "v1beta1"
+	case "v1beta1", "v1":
+	default:
+		return fmt.Errorf("kubeconfig apiVersion must be v1beta1 or v1, got %q", o.APIVersion)
+	}
+
+	switch o.Authenticator {
+	case "":
+		o.Authenticator = awsCLI
+	case awsCLI, awsIamAuthenticator:
+	default:
+		return fmt.Errorf("kubeconfig authenticator must be %q or %q, got %q", awsCLI, awsIamAuthenticator, o.Authenticator)
+	}
+
+	return nil
+}
+
+// exec returns the exec section of the kubeconfig user, which gets a token
+// for the cluster with the given name
+func (o kubeconfigOptions) exec(clusterName string) Exec {
+	exec := Exec{
+		APIVersion: "client.authentication.k8s.io/" + o.APIVersion,
+		Command:    o.Authenticator,
+	}
+
+	// the v1 API requires it, and neither authenticator prompts
+	if o.APIVersion == "v1" {
+		exec.InteractiveMode = "Never"
+	}
+
+	if o.Authenticator == awsCLI {
+		exec.Args = []string{"eks", "get-token", "--cluster-name", clusterName}
+		if o.Region != "" {
+			exec.Args = append(exec.Args, "--region", o.Region)
+		}
+		if o.RoleArn != "" {
+			exec.Args = append(exec.Args, "--role-arn", o.RoleArn)
+		}
+	} else {
+		exec.Args = []string{"token", "-i", clusterName}
+		if o.RoleArn != "" {
+			exec.Args = append(exec.Args, "-r", o.RoleArn)
+		}
+	}
+
+	if o.Profile != "" {
+		exec.Env = []ExecEnv{
+			{Name: "AWS_PROFILE", Value: o.Profile},
+		}
+	}
+
+	return exec
 }
 
-//Create the KubeConfig Structure as per https://docs.aws.amazon.com/eks/latest/userguide/create-kubeconfig.html
-func generateKubeconfig(clusterEndpoint pulumi.StringOutput, certData pulumi.StringOutput, clusterName pulumi.StringOutput) (pulumi.StringOutput, error) {
+// kubeconfigCluster is a cluster added to a kubeconfig
+type kubeconfigCluster struct {
+	Name                     string
+	Endpoint                 string
+	CertificateAuthorityData string
+}
 
-	rawKubeConfig, err := json.Marshal(&KubeConfig{
+// newKubeConfig builds the KubeConfig Structure as per
+// https://docs.aws.amazon.com/eks/latest/userguide/create-kubeconfig.html
+// Each cluster gets a user and a context named after it, plus a context named
+// <cluster>-<namespace> for each namespace. The first cluster is the current
+// context
+func newKubeConfig(clusters []kubeconfigCluster, options kubeconfigOptions) KubeConfig {
+	kubeConfig := KubeConfig{
 		APIVersion: "v1",
-		Clusters: []Clusters{
-			{
-				Cluster: Cluster{
-					Server:                   "%s",
-					CertificateAuthorityData: "%s",
-				},
-				Name: "kubernetes",
+		Kind:       "Config",
+	}
+
+	for _, cluster := range clusters {
+		kubeConfig.Clusters = append(kubeConfig.Clusters, Clusters{
+			Cluster: Cluster{
+				Server:                   cluster.Endpoint,
+				CertificateAuthorityData: cluster.CertificateAuthorityData,
 			},
-		},
-		Contexts: []Contexts{
-			{
+			Name: cluster.Name,
+		})
+		kubeConfig.Users = append(kubeConfig.Users, Users{
+			Name: cluster.Name,
+			User: User{
+				Exec: options.exec(cluster.Name),
+			},
+		})
+		kubeConfig.Contexts = append(kubeConfig.Contexts, Contexts{
+			Context: Context{
+				Cluster: cluster.Name,
+				User:    cluster.Name,
+			},
+			Name: cluster.Name,
+		})
+		for _, namespace := range options.Namespaces {
+			kubeConfig.Contexts = append(kubeConfig.Contexts, Contexts{
 				Context: Context{
-					Cluster: "kubernetes",
-					User:    "aws",
+					Cluster:   cluster.Name,
+					User:      cluster.Name,
+					Namespace: namespace,
 				},
-				Name: "aws",
-			},
-		},
-		CurrentContext: "aws",
-		Kind:           "Config",
-		Users: []Users{
+				Name: fmt.Sprintf("%s-%s", cluster.Name, namespace),
+			})
+		}
+	}
+
+	if len(clusters) > 0 {
+		kubeConfig.CurrentContext = clusters[0].Name
+	}
+
+	return kubeConfig
+}
+
+// JSON renders the kubeconfig as JSON
+func (k KubeConfig) JSON() (string, error) {
+	rendered, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	return string(rendered), nil
+}
+
+// YAML renders the kubeconfig as YAML
+func (k KubeConfig) YAML() (string, error) {
+	rendered, err := yaml.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	return string(rendered), nil
+}
+
+// generateKubeconfig returns a kubeconfig for the cluster as JSON and YAML,
+// once the cluster's endpoint and certificate are known
+func generateKubeconfig(clusterEndpoint pulumi.StringOutput, certData pulumi.StringOutput, clusterName pulumi.StringOutput, options kubeconfigOptions) (pulumi.StringOutput, pulumi.StringOutput, error) {
+	if err := options.validate(); err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, err
+	}
+
+	kubeConfig := pulumi.All(clusterEndpoint, certData, clusterName).ApplyT(func(args []interface{}) KubeConfig {
+		return newKubeConfig([]kubeconfigCluster{
 			{
-				Name: "aws",
-				User: User{
-					Exec: Exec{
-						APIVersion: "client.authentication.k8s.io/v1alpha1",
-						Command:    "aws-iam-authenticator",
-						Args: []string{
-							"token",
-							"-i",
-							"%s",
-						},
-					},
-				},
+				Name:                     args[2].(string),
+				Endpoint:                 args[0].(string),
+				CertificateAuthorityData: args[1].(string),
 			},
-		},
+		}, options)
 	})
 
-	if err != nil {
-		return pulumi.StringOutput{}, err
-	}
+	asJSON := kubeConfig.ApplyT(func(k interface{}) (string, error) {
+		return k.(KubeConfig).JSON()
+	}).(pulumi.StringOutput)
+	asYAML := kubeConfig.ApplyT(func(k interface{}) (string, error) {
+		return k.(KubeConfig).YAML()
+	}).(pulumi.StringOutput)
 
-	return pulumi.Sprintf(string(rawKubeConfig), clusterEndpoint, certData, clusterName), nil
+	return asJSON, asYAML, nil
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestKubeconfigExec(t *testing.T) {
+	tests := []struct {
+		name    string
+		options kubeconfigOptions
+		want    Exec
+	}{
+		{
+			name:    "defaults",
+			options: kubeconfigOptions{Region: "us-west-2"},
+			want: Exec{
+				APIVersion: "client.authentication.k8s.io/v1beta1",
+				Command:    "aws",
+				Args:       []string{"eks", "get-token", "--cluster-name", "lbriggs", "--region", "us-west-2"},
+			},
+		},
+		{
+			name: "aws cli with role and profile",
+			options: kubeconfigOptions{
+				APIVersion: "v1",
+				RoleArn:    "arn:aws:iam::123456789012:role/admin",
+				Profile:    "staging",
+				Region:     "us-west-2",
+			},
+			want: Exec{
+				APIVersion:      "client.authentication.k8s.io/v1",
+				Command:         "aws",
+				Args:            []string{"eks", "get-token", "--cluster-name", "lbriggs", "--region", "us-west-2", "--role-arn", "arn:aws:iam::123456789012:role/admin"},
+				Env:             []ExecEnv{{Name: "AWS_PROFILE", Value: "staging"}},
+				InteractiveMode: "Never",
+			},
+		},
+		{
+			name: "aws-iam-authenticator with role",
+			options: kubeconfigOptions{
+				Authenticator: "aws-iam-authenticator",
+				RoleArn:       "arn:aws:iam::123456789012:role/admin",
+			},
+			want: Exec{
+				APIVersion: "client.authentication.k8s.io/v1beta1",
+				Command:    "aws-iam-authenticator",
+				Args:       []string{"token", "-i", "lbriggs", "-r", "arn:aws:iam::123456789012:role/admin"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.options.exec("lbriggs"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeconfigInvalidOptions(t *testing.T) {
+	for _, options := range []kubeconfigOptions{
+		{APIVersion: "v1alpha1"},
+		{Authenticator: "heptio-authenticator-aws"},
+	} {
+		if err := options.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", options)
+		}
+	}
+}
+
+func TestKubeConfigEscaping(t *testing.T) {
+	cluster := kubeconfigCluster{
+		Name:                     `odd"%s\name`,
+		Endpoint:                 "https://example.com/%d?q=\"quoted\"",
+		CertificateAuthorityData: "Y2VydGlm%aWNhdGU=",
+	}
+	options := kubeconfigOptions{Region: "us-west-2"}
+	if err := options.validate(); err != nil {
+		t.Fatal(err)
+	}
+	kubeConfig := newKubeConfig([]kubeconfigCluster{cluster}, options)
+
+	rendered, err := kubeConfig.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON KubeConfig
+	if err := json.Unmarshal([]byte(rendered), &fromJSON); err != nil {
+		t.Fatalf("invalid JSON %s: %v", rendered, err)
+	}
+
+	rendered, err = kubeConfig.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromYAML KubeConfig
+	if err := yaml.Unmarshal([]byte(rendered), &fromYAML); err != nil {
+		t.Fatalf("invalid YAML %s: %v", rendered, err)
+	}
+
+	for format, parsed := range map[string]KubeConfig{"JSON": fromJSON, "YAML": fromYAML} {
+		if !reflect.DeepEqual(parsed, kubeConfig) {
+			t.Errorf("%s round trip = %+v, want %+v", format, parsed, kubeConfig)
+		}
+		if got := parsed.Clusters[0].Cluster.Server; got != cluster.Endpoint {
+			t.Errorf("%s server = %q, want %q", format, got, cluster.Endpoint)
+		}
+		if got := parsed.Users[0].User.Exec.Args[3]; got != cluster.Name {
+			t.Errorf("%s cluster name argument = %q, want %q", format, got, cluster.Name)
+		}
+	}
+}
+
+func TestKubeConfigMultipleClusters(t *testing.T) {
+	options := kubeconfigOptions{Namespaces: []string{"kong", "sock-shop"}}
+	if err := options.validate(); err != nil {
+		t.Fatal(err)
+	}
+	kubeConfig := newKubeConfig([]kubeconfigCluster{
+		{Name: "blue", Endpoint: "https://blue.example.com", CertificateAuthorityData: "Ymx1ZQ=="},
+		{Name: "green", Endpoint: "https://green.example.com", CertificateAuthorityData: "Z3JlZW4="},
+	}, options)
+
+	if kubeConfig.CurrentContext != "blue" {
+		t.Errorf("current context = %q, want the first cluster", kubeConfig.CurrentContext)
+	}
+	if len(kubeConfig.Clusters) != 2 || len(kubeConfig.Users) != 2 {
+		t.Fatalf("expected a cluster and user for each cluster, got %d and %d", len(kubeConfig.Clusters), len(kubeConfig.Users))
+	}
+
+	contexts := map[string]Context{}
+	for _, c := range kubeConfig.Contexts {
+		contexts[c.Name] = c.Context
+	}
+	want := map[string]Context{
+		"blue":            {Cluster: "blue", User: "blue"},
+		"blue-kong":       {Cluster: "blue", User: "blue", Namespace: "kong"},
+		"blue-sock-shop":  {Cluster: "blue", User: "blue", Namespace: "sock-shop"},
+		"green":           {Cluster: "green", User: "green"},
+		"green-kong":      {Cluster: "green", User: "green", Namespace: "kong"},
+		"green-sock-shop": {Cluster: "green", User: "green", Namespace: "sock-shop"},
+	}
+	if !reflect.DeepEqual(contexts, want) {
+		t.Errorf("contexts = %v, want %v", contexts, want)
+	}
+
+	for _, user := range kubeConfig.Users {
+		if user.User.Exec.Args[3] != user.Name {
+			t.Errorf("user %s gets a token for cluster %s", user.Name, user.User.Exec.Args[3])
+		}
+	}
+}
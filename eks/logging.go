@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// controlPlaneLogTypes are the control plane components which can log to
+// CloudWatch
+var controlPlaneLogTypes = []string{"api", "audit", "authenticator", "controllerManager", "scheduler"}
+
+// loggingConfig is the eks.go:logging config object. Logging is off unless
+// types lists the log types to enable
+type loggingConfig struct {
+	Types         []string `json:"types"`
+	RetentionDays int      `json:"retentionDays"`
+}
+
+// validate checks the config and fills in the defaults
+func (c *loggingConfig) validate() error {
+	for _, logType := range c.Types {
+		known := false
+		for _, t := range controlPlaneLogTypes {
+			known = known || t == logType
+		}
+		if !known {
+			return fmt.Errorf("unknown control plane log type %q, must be one of %v", logType, controlPlaneLogTypes)
+		}
+	}
+
+	if c.RetentionDays == 0 {
+		c.RetentionDays = 90
+	}
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("control plane log retention must be positive, got %d days", c.RetentionDays)
+	}
+
+	return nil
+}
+
+// newControlPlaneLogs creates the log group EKS writes the cluster's control
+// plane logs to, so it's tagged and has a retention. The group is nil when
+// logging is disabled
+func newControlPlaneLogs(ctx *pulumi.Context, clusterName string, conf *loggingConfig) (*cloudwatch.LogGroup, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	if len(conf.Types) == 0 {
+		return nil, nil
+	}
+
+	return cloudwatch.NewLogGroup(ctx, "control-plane", &cloudwatch.LogGroupArgs{
+		Name:            pulumi.String(fmt.Sprintf("/aws/eks/%s/cluster", clusterName)),
+		RetentionInDays: pulumi.Int(conf.RetentionDays),
+	})
+}
@@ -1,180 +1,296 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"regexp"
 
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/eks"
+	"github.com/jaxxstorm/iac-in-go/lib/awsprovider"
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/jaxxstorm/iac-in-go/lib/tags"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
 
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ec2"
 
-	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/iam"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/providers"
 	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi/config"
 )
 
+// clusterName is the name of the EKS cluster
+const clusterName = "lbriggs"
+
+// kubernetesVersionPattern matches the Kubernetes minor versions EKS runs
+var kubernetesVersionPattern = regexp.MustCompile(`^1\.[0-9]+$`)
+
 func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
+	pulumi.Run(createStack)
+}
 
-		/*
-		 * Construct a slug which references another stack to use in our stack reference
-		 */
-		slug := fmt.Sprintf("jaxxstorm/vpc.go/%v", ctx.Stack())
-		vpc, err := pulumi.NewStackReference(ctx, slug, nil)
-		if err != nil {
-			fmt.Errorf("Error getting vpc stack reference: %w", err)
-		}
+func createStack(ctx *pulumi.Context) error {
+	/*
+	 * Tag every AWS resource in the stack
+	 */
+	if err := tags.Register(ctx); err != nil {
+		return err
+	}
 
-		/*
-		 * Add the IAM Role and policies to use EKS
-		 */
-		eksAssumeRolePolicyJSON, _ := json.Marshal(map[string]interface{}{
-			"Version": "2012-10-17",
-			"Statement": []interface{}{
-				map[string]interface{}{
-					"Action": "sts:AssumeRole",
-					"Principal": map[string]interface{}{
-						"Service": []interface{}{
-							"eks.amazonaws.com",
-						},
-					},
-					"Effect": "Allow",
-				},
-			},
-		})
-		eksRole, err := iam.NewRole(ctx, "eks-iam-eksRole", &iam.RoleArgs{
-			AssumeRolePolicy: pulumi.String(eksAssumeRolePolicyJSON),
-		})
-		if err != nil {
-			return err
-		}
-		eksPolicies := []string{
-			"arn:aws:iam::aws:policy/AmazonEKSServicePolicy",
-			"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy",
-		}
-		for i, eksPolicy := range eksPolicies {
-			_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("rpa-%d", i), &iam.RolePolicyAttachmentArgs{
-				PolicyArn: pulumi.String(eksPolicy),
-				Role:      eksRole.Name,
-			}, pulumi.Parent(eksRole))
-			if err != nil {
-				return err
-			}
-		}
+	/*
+	 * Look up the account and region
+	 * the stack deploys to
+	 */
+	account, err := awsprovider.Lookup(ctx)
+	if err != nil {
+		return err
+	}
 
-		nodeGroupAssumeRolePolicyJSON, _ := json.Marshal(map[string]interface{}{
-			"Version": "2012-10-17",
-			"Statement": []interface{}{
-				map[string]interface{}{
-					"Action": "sts:AssumeRole",
-					"Principal": map[string]interface{}{
-						"Service": []interface{}{
-							"ec2.amazonaws.com",
-						},
-					},
-					"Effect": "Allow",
-				},
-			},
-		})
-		// Create the EC2 NodeGroup Role
-		nodeGroupRole, err := iam.NewRole(ctx, "nodegroup-iam-role", &iam.RoleArgs{
-			AssumeRolePolicy: pulumi.String(nodeGroupAssumeRolePolicyJSON),
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
-			},
-		})
-		if err != nil {
-			return err
-		}
-		nodeGroupPolicies := []string{
-			"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
-			"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
-			"arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly",
-		}
+	/*
+	 * Grab the VPC stack outputs
+	 */
+	vpc, err := stackref.Vpc(ctx)
+	if err != nil {
+		return err
+	}
 
-		for i, nodeGroupPolicy := range nodeGroupPolicies {
-			_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("ngpa-%d", i), &iam.RolePolicyAttachmentArgs{
-				Role:      nodeGroupRole.Name,
-				PolicyArn: pulumi.String(nodeGroupPolicy),
-			}, pulumi.Parent(nodeGroupRole))
-			if err != nil {
-				return err
-			}
-		}
+	/*
+	 * Pin the cluster's Kubernetes version, which
+	 * the nodes' AMIs and the add-ons follow
+	 */
+	kubernetesVersion := config.New(ctx, "").Get("kubernetesVersion")
+	if !kubernetesVersionPattern.MatchString(kubernetesVersion) {
+		return fmt.Errorf("eks.go:kubernetesVersion must be the cluster's Kubernetes version, e.g. 1.18, got %q", kubernetesVersion)
+	}
 
-		// Create a Security Group that we can use to actually connect to our cluster
-		clusterSg, err := ec2.NewSecurityGroup(ctx, "cluster-sg", &ec2.SecurityGroupArgs{
-			VpcId: vpc.GetStringOutput(pulumi.String("id")),
-			Egress: ec2.SecurityGroupEgressArray{
-				ec2.SecurityGroupEgressArgs{
-					Protocol:   pulumi.String("-1"),
-					FromPort:   pulumi.Int(0),
-					ToPort:     pulumi.Int(0),
-					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				},
-			},
-			Ingress: ec2.SecurityGroupIngressArray{
-				ec2.SecurityGroupIngressArgs{
-					Protocol:   pulumi.String("tcp"),
-					FromPort:   pulumi.Int(80),
-					ToPort:     pulumi.Int(80),
-					CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
-				},
-			},
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
-			},
-		})
+	/*
+	 * Add the IAM Role and policies to use EKS
+	 */
+	eksAssumeRolePolicyJSON, err := policy.ServiceAssumeRole("eks.amazonaws.com").Render()
+	if err != nil {
+		return err
+	}
+	eksRole, err := iam.NewRole(ctx, "eks-iam-eksRole", &iam.RoleArgs{
+		AssumeRolePolicy: eksAssumeRolePolicyJSON,
+	})
+	if err != nil {
+		return err
+	}
+	eksPolicies := []string{
+		"arn:aws:iam::aws:policy/AmazonEKSServicePolicy",
+		"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy",
+	}
+	for i, eksPolicy := range eksPolicies {
+		_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("rpa-%d", i), &iam.RolePolicyAttachmentArgs{
+			PolicyArn: pulumi.String(eksPolicy),
+			Role:      eksRole.Name,
+		}, pulumi.Parent(eksRole))
 		if err != nil {
 			return err
 		}
+	}
 
-		// Create EKS Cluster
-		eksCluster, err := eks.NewCluster(ctx, "eks-cluster", &eks.ClusterArgs{
-			Name:    pulumi.String("lbriggs"),
-			RoleArn: pulumi.StringInput(eksRole.Arn),
-			VpcConfig: &eks.ClusterVpcConfigArgs{
-				PublicAccessCidrs: pulumi.StringArray{
-					pulumi.String("0.0.0.0/0"),
-				},
-				SecurityGroupIds: pulumi.StringArray{
-					clusterSg.ID().ToStringOutput(),
-				},
-				SubnetIds: pulumi.StringArrayOutput(vpc.GetOutput(pulumi.String("privateSubnets"))),
-			},
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
-			},
-		})
+	nodeGroupAssumeRolePolicyJSON, err := policy.ServiceAssumeRole("ec2.amazonaws.com").Render()
+	if err != nil {
+		return err
+	}
+
+	// Create the EC2 NodeGroup Role
+	nodeGroupRole, err := iam.NewRole(ctx, "nodegroup-iam-role", &iam.RoleArgs{
+		AssumeRolePolicy: nodeGroupAssumeRolePolicyJSON,
+	})
+	if err != nil {
+		return err
+	}
+	nodeGroupPolicies := []string{
+		"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
+		"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
+		"arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly",
+	}
+
+	for i, nodeGroupPolicy := range nodeGroupPolicies {
+		_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("ngpa-%d", i), &iam.RolePolicyAttachmentArgs{
+			Role:      nodeGroupRole.Name,
+			PolicyArn: pulumi.String(nodeGroupPolicy),
+		}, pulumi.Parent(nodeGroupRole))
 		if err != nil {
 			return err
 		}
+	}
 
-		_, err = eks.NewNodeGroup(ctx, "node-group", &eks.NodeGroupArgs{
-			ClusterName:   eksCluster.Name,
-			NodeGroupName: pulumi.String("node-group"),
-			NodeRoleArn:   pulumi.StringInput(nodeGroupRole.Arn),
-			SubnetIds:     pulumi.StringArrayOutput(vpc.GetOutput(pulumi.String("privateSubnets"))),
-			ScalingConfig: &eks.NodeGroupScalingConfigArgs{
-				DesiredSize: pulumi.Int(2),
-				MaxSize:     pulumi.Int(2),
-				MinSize:     pulumi.Int(1),
-			},
-			Tags: pulumi.Map{
-				"Owner": pulumi.String("lbriggs"),
+	/*
+	 * Decide where the API server can be reached from
+	 */
+	var endpoint endpointConfig
+	if err := config.New(ctx, "").GetObject("endpoint", &endpoint); err != nil {
+		return err
+	}
+	if err := endpoint.validate(); err != nil {
+		return err
+	}
+
+	ingress, err := endpoint.ingress(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Create a Security Group that we can use to actually connect to our cluster
+	clusterSg, err := ec2.NewSecurityGroup(ctx, "cluster-sg", &ec2.SecurityGroupArgs{
+		VpcId: vpc.ID,
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
 			},
-		}, pulumi.Parent(eksCluster))
+		},
+		Ingress: ingress,
+	})
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Send the control plane logs to a log
+	 * group we manage, and encrypt secrets
+	 */
+	var logging loggingConfig
+	if err := config.New(ctx, "").GetObject("logging", &logging); err != nil {
+		return err
+	}
+
+	logGroup, err := newControlPlaneLogs(ctx, clusterName, &logging)
+	if err != nil {
+		return err
+	}
+
+	clusterArgs := &eks.ClusterArgs{
+		Name:    pulumi.String(clusterName),
+		Version: pulumi.String(kubernetesVersion),
+		RoleArn: pulumi.StringInput(eksRole.Arn),
+		VpcConfig: endpoint.vpcConfig(pulumi.StringArray{
+			clusterSg.ID().ToStringOutput(),
+		}, vpc.PrivateSubnets),
+		EnabledClusterLogTypes: pulumi.StringArray(policy.Strings(logging.Types...)),
+	}
+
+	// adding encryption to a running cluster replaces it, so it's opt in
+	if config.New(ctx, "").GetBool("encryptSecrets") {
+		clusterArgs.EncryptionConfig, err = newSecretsEncryption(ctx, clusterName, eksRole)
 		if err != nil {
 			return err
 		}
+	}
+
+	var clusterOpts []pulumi.ResourceOption
+	if logGroup != nil {
+		clusterOpts = append(clusterOpts, pulumi.DependsOn([]pulumi.Resource{logGroup}))
+	}
 
-		kubeConfig, err := generateKubeconfig(eksCluster.Endpoint, eksCluster.CertificateAuthority.Data().Elem(), eksCluster.Name)
+	// an existing cluster may have an aws-auth the stack doesn't manage yet
+	clusterExisted, err := clusterExists(ctx, clusterName)
+	if err != nil {
+		return err
+	}
 
+	// Create EKS Cluster
+	eksCluster, err := eks.NewCluster(ctx, "eks-cluster", clusterArgs, clusterOpts...)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Generate a kubeconfig which authenticates
+	 * the way the config asks for
+	 */
+	var kubeconfig kubeconfigOptions
+	if err := config.New(ctx, "").GetObject("kubeconfig", &kubeconfig); err != nil {
+		return err
+	}
+	kubeconfig.Region = account.Region
+
+	kubeConfig, kubeConfigYAML, err := generateKubeconfig(eksCluster.Endpoint, eksCluster.CertificateAuthority.Data().Elem(), eksCluster.Name, kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Manage who can use the cluster, making
+	 * sure the nodes can always join it
+	 */
+	k8sProvider, err := providers.NewProvider(ctx, "k8sprovider", &providers.ProviderArgs{
+		Kubeconfig:                  kubeConfig,
+		SuppressDeprecationWarnings: pulumi.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	var access []accessMapping
+	if err := config.New(ctx, "").GetObject("access", &access); err != nil {
+		return err
+	}
+
+	awsAuth, err := newAccess(ctx, nodeGroupRole.Arn, access, clusterExisted, pulumi.Provider(k8sProvider))
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Create the managed node groups, by
+	 * default a single group of 1 to 2 nodes
+	 */
+	var nodeGroups []nodeGroupConfig
+	if err := config.New(ctx, "").GetObject("nodeGroups", &nodeGroups); err != nil {
+		return err
+	}
+	if len(nodeGroups) == 0 {
+		nodeGroups = defaultNodeGroups
+	}
+
+	nodes, err := newNodeGroups(ctx, eksCluster, kubernetesVersion, nodeGroupRole.Arn, vpc.PrivateSubnets, nodeGroups,
+		pulumi.DependsOn([]pulumi.Resource{awsAuth}))
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Trust the cluster's OIDC issuer so
+	 * service accounts can assume IAM roles
+	 */
+	oidcProvider, issuer, err := newOidcProvider(ctx, eksCluster)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Pin the versions of the cluster's add-ons,
+	 * once there are nodes for them to run on
+	 */
+	var addons map[string]addonConfig
+	if err := config.New(ctx, "").GetObject("addons", &addons); err != nil {
+		return err
+	}
+	if addons == nil {
+		addons, err = defaultAddonsFor(kubernetesVersion)
 		if err != nil {
 			return err
 		}
+	}
 
-		ctx.Export("kubeconfig", kubeConfig)
+	ebsCsiRoleArn, err := newAddons(ctx, eksCluster, oidcProvider.Arn, addons, pulumi.DependsOn(nodes))
+	if err != nil {
+		return err
+	}
 
-		return nil
-	})
+	ctx.Export("clusterName", eksCluster.Name)
+	ctx.Export("kubernetesVersion", eksCluster.Version)
+	ctx.Export("kubeconfig", pulumi.ToSecret(kubeConfig))
+	ctx.Export("kubeconfigYaml", pulumi.ToSecret(kubeConfigYAML))
+	ctx.Export("oidcProviderArn", oidcProvider.Arn)
+	ctx.Export("oidcIssuerUrl", issuer)
+	ctx.Export("ebsCsiRoleArn", ebsCsiRoleArn)
+	ctx.Export(awsAuthManagedOutput, pulumi.Bool(true))
+
+	return nil
 }
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+const issuer = "https://oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF"
+
+func newMocks() *mocks.Mocks {
+	clusterThumbprint = func(string) (string, error) {
+		return "9e99a48a9960b14926bb7f3b02e22da2b0ab7280", nil
+	}
+
+	return mocks.Default().SetResourceOutputs("aws:eks/cluster:Cluster", map[string]interface{}{
+		"endpoint": "https://0123456789ABCDEF.gr7.us-west-2.eks.amazonaws.com",
+		"version":  "1.18",
+		"certificateAuthority": map[string]interface{}{
+			"data": "Y2VydGlmaWNhdGU=",
+		},
+		"identities": []interface{}{
+			map[string]interface{}{
+				"oidcs": []interface{}{
+					map[string]interface{}{"issuer": issuer},
+				},
+			},
+		},
+	}).SetResourceOutputs("aws:iam/openIdConnectProvider:OpenIdConnectProvider", map[string]interface{}{
+		"arn": "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF",
+	}).SetResourceOutputs("aws:ec2/launchTemplate:LaunchTemplate", map[string]interface{}{
+		"latestVersion": 1,
+	}).SetCallResult("aws:ssm/getParameter:getParameter", map[string]interface{}{
+		"value": "ami-0123456789abcdef0",
+	}).SetCallError("aws:eks/getCluster:getCluster", errors.New("ResourceNotFoundException: No cluster found for name: lbriggs.")).
+		SetConfig("eks.go:kubernetesVersion", "1.18").
+		SetConfig("eks.go:endpoint", `{"publicAccessCidrs": ["203.0.113.0/24"]}`)
+}
+
+func TestClusterNetworking(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, ok := m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	if !ok {
+		t.Fatal("cluster was not created")
+	}
+	vpcConfig := cluster.Inputs["vpcConfig"].ObjectValue()
+	for _, subnet := range vpcConfig["subnetIds"].ArrayValue() {
+		if !strings.HasPrefix(subnet.StringValue(), "subnet-private") {
+			t.Errorf("cluster should only use private subnets, got %s", subnet.StringValue())
+		}
+	}
+
+	nodeGroup, ok := m.Resource("aws:eks/nodeGroup:NodeGroup", "node-group")
+	if !ok {
+		t.Fatal("node group was not created")
+	}
+	if n := len(nodeGroup.Inputs["subnetIds"].ArrayValue()); n != 3 {
+		t.Errorf("node group should span the 3 private subnets, got %d", n)
+	}
+}
+
+func TestKubernetesVersion(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, ok := m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	if !ok {
+		t.Fatal("cluster was not created")
+	}
+	if version := cluster.Inputs["version"].StringValue(); version != "1.18" {
+		t.Errorf("cluster version = %s, want the configured 1.18", version)
+	}
+
+	for _, version := range []string{"", "1", "v1.18", "1.18.9"} {
+		err := newMocks().Run("eks.go", createStack, mocks.Config(map[string]string{
+			"eks.go:kubernetesVersion": version,
+		}))
+		if err == nil || !strings.Contains(err.Error(), "eks.go:kubernetesVersion") {
+			t.Errorf("version %q: expected a version error, got %v", version, err)
+		}
+	}
+}
+
+func TestPublicEndpoint(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, _ := m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	vpcConfig := cluster.Inputs["vpcConfig"].ObjectValue()
+	if !vpcConfig["endpointPublicAccess"].BoolValue() || vpcConfig["endpointPrivateAccess"].BoolValue() {
+		t.Error("the cluster endpoint should be public by default")
+	}
+	if cidrs := vpcConfig["publicAccessCidrs"].ArrayValue(); len(cidrs) != 1 || cidrs[0].StringValue() != "203.0.113.0/24" {
+		t.Errorf("public access CIDRs = %v, want [203.0.113.0/24]", cidrs)
+	}
+
+	sg, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "cluster-sg")
+	if !ok {
+		t.Fatal("cluster security group was not created")
+	}
+	if rules := sg.Ingress(); len(rules) != 0 {
+		t.Errorf("cluster security group should have no ingress without Tailscale, got %+v", rules)
+	}
+	if _, ok := m.Resource("pulumi:pulumi:StackReference", "jaxxstorm/bastion.go/"+mocks.Stack); ok {
+		t.Error("the bastion stack should only be read when Tailscale access is enabled")
+	}
+}
+
+func TestPrivateEndpoint(t *testing.T) {
+	m := newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:endpoint": `{"publicAccess": false, "privateAccess": true, "tailscale": true}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, _ := m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	vpcConfig := cluster.Inputs["vpcConfig"].ObjectValue()
+	if !vpcConfig["endpointPrivateAccess"].BoolValue() || vpcConfig["endpointPublicAccess"].BoolValue() {
+		t.Error("the cluster endpoint should be private")
+	}
+	if cidrs, ok := vpcConfig["publicAccessCidrs"]; ok && len(cidrs.ArrayValue()) > 0 {
+		t.Errorf("a private cluster should have no public access CIDRs, got %v", cidrs)
+	}
+
+	sg, _ := m.Resource("aws:ec2/securityGroup:SecurityGroup", "cluster-sg")
+	tailscale := false
+	for _, rule := range sg.Ingress() {
+		if rule.Allows("0.0.0.0/0", 80) || rule.Allows("0.0.0.0/0", 443) {
+			t.Errorf("cluster security group should not be open to the world, got %+v", rule)
+		}
+		tailscale = tailscale || rule.Allows("sg-bastion", 443)
+	}
+	if !tailscale {
+		t.Error("the Tailscale bastion should reach the API server")
+	}
+}
+
+func TestEndpointInvalidConfig(t *testing.T) {
+	for name, endpoint := range map[string]string{
+		"no access":         `{"publicAccess": false}`,
+		"open to world":     `{"publicAccessCidrs": ["0.0.0.0/0"]}`,
+		"no allow-list":     `{}`,
+		"invalid cidr":      `{"publicAccessCidrs": ["203.0.113.0"]}`,
+		"private cidrs":     `{"publicAccess": false, "privateAccess": true, "publicAccessCidrs": ["203.0.113.0/24"]}`,
+		"tailscale public":  `{"tailscale": true, "publicAccessCidrs": ["203.0.113.0/24"]}`,
+		"tailscale private": `{"publicAccess": false, "tailscale": true}`,
+	} {
+		m := newMocks()
+		err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+			"eks.go:endpoint": endpoint,
+		}))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// existingCluster returns mocks for a stack whose cluster has already been
+// created, with the aws-auth ConfigMap EKS made for its nodes
+func existingCluster() *mocks.Mocks {
+	return newMocks().SetCallResult("aws:eks/getCluster:getCluster", map[string]interface{}{
+		"name": clusterName,
+	}).SetResourceOutputs("kubernetes:core/v1:ConfigMap", map[string]interface{}{
+		"data": map[string]interface{}{
+			"mapRoles": "- rolearn: arn:aws:iam::123456789012:role/nodegroup-iam-role\n",
+		},
+	})
+}
+
+func TestAdoptAwsAuth(t *testing.T) {
+	m := existingCluster()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:access": `[{"arn": "arn:aws:iam::123456789012:role/platform", "groups": ["admin"]}]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("kubernetes:core/v1:ConfigMap", "aws-auth-existing"); !ok {
+		t.Error("the existing aws-auth ConfigMap should be read")
+	}
+	awsAuth, ok := m.Resource("kubernetes:core/v1:ConfigMap", "aws-auth")
+	if !ok {
+		t.Fatal("aws-auth ConfigMap was not adopted")
+	}
+	if awsAuth.ID != awsAuthID {
+		t.Errorf("aws-auth should be imported as %s, got %q", awsAuthID, awsAuth.ID)
+	}
+	if mapRoles := awsAuth.Inputs["data"].ObjectValue()["mapRoles"].StringValue(); strings.Contains(mapRoles, "role/platform") {
+		t.Errorf("aws-auth should be adopted with its existing data, got %s", mapRoles)
+	}
+}
+
+func TestManagedAwsAuth(t *testing.T) {
+	m := existingCluster().SetStackOutputs("jaxxstorm/eks.go/"+mocks.Stack, map[string]interface{}{
+		"clusterName":        clusterName,
+		awsAuthManagedOutput: true,
+	})
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:access": `[{"arn": "arn:aws:iam::123456789012:role/platform", "groups": ["admin"]}]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	awsAuth, ok := m.Resource("kubernetes:core/v1:ConfigMap", "aws-auth")
+	if !ok {
+		t.Fatal("aws-auth ConfigMap was not created")
+	}
+	if awsAuth.ID != "" {
+		t.Errorf("aws-auth the stack already manages should not be imported, got %q", awsAuth.ID)
+	}
+	if mapRoles := awsAuth.Inputs["data"].ObjectValue()["mapRoles"].StringValue(); !strings.Contains(mapRoles, "role/platform") {
+		t.Errorf("aws-auth should have the configured mappings, got %s", mapRoles)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	m := newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:access": `[{"arn": "arn:aws:iam::123456789012:role/platform", "groups": ["admin"]}]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("kubernetes:core/v1:ConfigMap", "aws-auth-existing"); ok {
+		t.Error("a new cluster has no aws-auth ConfigMap to read")
+	}
+	awsAuth, ok := m.Resource("kubernetes:core/v1:ConfigMap", "aws-auth")
+	if !ok {
+		t.Fatal("aws-auth ConfigMap was not created")
+	}
+	if awsAuth.ID != "" {
+		t.Errorf("aws-auth on a new cluster should be created, not imported as %q", awsAuth.ID)
+	}
+	mapRoles := awsAuth.Inputs["data"].ObjectValue()["mapRoles"].StringValue()
+	for _, want := range []string{"system:nodes", "arn:aws:iam::123456789012:role/platform"} {
+		if !strings.Contains(mapRoles, want) {
+			t.Errorf("mapRoles should contain %s, got %s", want, mapRoles)
+		}
+	}
+
+	for group, role := range map[string]string{"read-only": "view", "admin": "cluster-admin"} {
+		binding, ok := m.Resource("kubernetes:rbac.authorization.k8s.io/v1:ClusterRoleBinding", group)
+		if !ok {
+			t.Errorf("%s group is not bound", group)
+			continue
+		}
+		if name := binding.Inputs["roleRef"].ObjectValue()["name"].StringValue(); name != role {
+			t.Errorf("%s group is bound to %s, want %s", group, name, role)
+		}
+	}
+
+	m = newMocks()
+	err = m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:access": `[{"arn": "arn:aws:iam::123456789012:group/admins", "groups": ["admin"]}]`,
+	}))
+	if err == nil {
+		t.Error("expected an error for an IAM group")
+	}
+}
+
+func TestControlPlaneLogging(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, _ := m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	if types, ok := cluster.Inputs["enabledClusterLogTypes"]; ok && len(types.ArrayValue()) > 0 {
+		t.Errorf("control plane logging should be off by default, got %v", types)
+	}
+	if _, ok := m.Resource("aws:cloudwatch/logGroup:LogGroup", "control-plane"); ok {
+		t.Error("no log group should be created when logging is off")
+	}
+
+	m = newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:logging": `{"types": ["api", "audit"]}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cluster, _ = m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	if types := cluster.Inputs["enabledClusterLogTypes"].ArrayValue(); len(types) != 2 {
+		t.Errorf("the configured log types should be enabled, got %v", types)
+	}
+
+	logGroup, ok := m.Resource("aws:cloudwatch/logGroup:LogGroup", "control-plane")
+	if !ok {
+		t.Fatal("control plane log group was not created")
+	}
+	if name := logGroup.Inputs["name"].StringValue(); name != "/aws/eks/lbriggs/cluster" {
+		t.Errorf("log group name = %s, want /aws/eks/lbriggs/cluster", name)
+	}
+	if days := logGroup.Inputs["retentionInDays"].NumberValue(); days != 90 {
+		t.Errorf("log group retention = %v days, want 90", days)
+	}
+
+	m = newMocks()
+	err = m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:logging": `{"types": ["kubelet"]}`,
+	}))
+	if err == nil {
+		t.Error("expected an error for an unknown log type")
+	}
+}
+
+func TestSecretsEncryption(t *testing.T) {
+	m := newMocks().SetResourceOutputs("aws:kms/key:Key", map[string]interface{}{
+		"arn":               "arn:aws:kms:us-west-2:123456789012:key/0123abcd",
+		"enableKeyRotation": true,
+	})
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:encryptSecrets": "true",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, ok := m.Resource("aws:kms/key:Key", "eks-secrets")
+	if !ok {
+		t.Fatal("secrets key was not created")
+	}
+	if !key.Inputs["enableKeyRotation"].BoolValue() {
+		t.Error("secrets key should be rotated")
+	}
+
+	cluster, _ := m.Resource("aws:eks/cluster:Cluster", "eks-cluster")
+	encryption := cluster.Inputs["encryptionConfig"].ObjectValue()
+	if arn := encryption["provider"].ObjectValue()["keyArn"].StringValue(); arn != "arn:aws:kms:us-west-2:123456789012:key/0123abcd" {
+		t.Errorf("cluster secrets key = %s", arn)
+	}
+
+	// encrypting an existing cluster's secrets replaces it
+	m = newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Resource("aws:kms/key:Key", "eks-secrets"); ok {
+		t.Error("secrets encryption should be opt in")
+	}
+	if cluster, _ := m.Resource("aws:eks/cluster:Cluster", "eks-cluster"); cluster.Inputs.HasValue("encryptionConfig") {
+		t.Error("the cluster should not be encrypted by default")
+	}
+}
+
+func TestNodeGroupsConfig(t *testing.T) {
+	m := newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:nodeGroups": `[
+			{"name": "general", "minSize": 1, "desiredSize": 2, "maxSize": 4, "instanceTypes": ["m5.large"], "diskSize": 50},
+			{"name": "batch", "minSize": 0, "desiredSize": 0, "maxSize": 10, "amiType": "AL2_x86_64", "labels": {"workload": "batch"}},
+			{"name": "spot", "minSize": 0, "desiredSize": 0, "maxSize": 10, "capacityType": "SPOT", "instanceTypes": ["m5.large", "m5a.large"],
+				"launchTemplate": {"id": "lt-0123456789abcdef0", "version": "3"}}
+		]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("aws:eks/nodeGroup:NodeGroup", "node-group"); ok {
+		t.Error("the default node group should not be created when groups are configured")
+	}
+
+	general, ok := m.Resource("aws:eks/nodeGroup:NodeGroup", "general")
+	if !ok {
+		t.Fatal("node group general was not created")
+	}
+	if types := general.Inputs["instanceTypes"].ArrayValue(); len(types) != 1 || types[0].StringValue() != "m5.large" {
+		t.Errorf("general instance types = %v, want [m5.large]", types)
+	}
+	if diskSize := general.Inputs["diskSize"].NumberValue(); diskSize != 50 {
+		t.Errorf("general disk size = %v, want 50", diskSize)
+	}
+	if maxSize := general.Inputs["scalingConfig"].ObjectValue()["maxSize"].NumberValue(); maxSize != 4 {
+		t.Errorf("general max size = %v, want 4", maxSize)
+	}
+
+	batch, ok := m.Resource("aws:eks/nodeGroup:NodeGroup", "batch")
+	if !ok {
+		t.Fatal("node group batch was not created")
+	}
+	if amiType := batch.Inputs["amiType"].StringValue(); amiType != "AL2_x86_64" {
+		t.Errorf("batch AMI type = %s, want AL2_x86_64", amiType)
+	}
+	if label := batch.Inputs["labels"].ObjectValue()["workload"].StringValue(); label != "batch" {
+		t.Errorf("batch workload label = %s, want batch", label)
+	}
+
+	spot, ok := m.Resource("aws:eks/nodeGroup:NodeGroup", "spot")
+	if !ok {
+		t.Fatal("node group spot was not created")
+	}
+	if capacityType := spot.Inputs["capacityType"].StringValue(); capacityType != "SPOT" {
+		t.Errorf("spot capacity type = %s, want SPOT", capacityType)
+	}
+	if n := len(spot.Inputs["instanceTypes"].ArrayValue()); n != 2 {
+		t.Errorf("spot should have 2 instance types, got %d", n)
+	}
+	template := spot.Inputs["launchTemplate"].ObjectValue()
+	if template["id"].StringValue() != "lt-0123456789abcdef0" || template["version"].StringValue() != "3" {
+		t.Errorf("spot launch template = %v, want lt-0123456789abcdef0 version 3", template)
+	}
+	if len(m.Resources("aws:ec2/launchTemplate:LaunchTemplate")) != 0 {
+		t.Error("launch templates should only be created for tainted groups")
+	}
+}
+
+func TestNodeGroupTaints(t *testing.T) {
+	m := newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:nodeGroups": `[
+			{"name": "gpu", "minSize": 0, "desiredSize": 1, "maxSize": 2, "diskSize": 100, "labels": {"workload": "gpu"},
+				"taints": [{"key": "nvidia.com/gpu", "value": "true", "effect": "NoSchedule"}, {"key": "dedicated", "effect": "NoExecute"}]}
+		]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template, ok := m.Resource("aws:ec2/launchTemplate:LaunchTemplate", "gpu")
+	if !ok {
+		t.Fatal("launch template for the tainted group was not created")
+	}
+	if ami := template.Inputs["imageId"].StringValue(); ami != "ami-0123456789abcdef0" {
+		t.Errorf("tainted group AMI = %s, want the EKS optimized AMI", ami)
+	}
+	userData, err := base64.StdEncoding.DecodeString(template.Inputs["userData"].StringValue())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"/etc/eks/bootstrap.sh lbriggs",
+		"--register-with-taints=nvidia.com/gpu=true:NoSchedule,dedicated:NoExecute",
+		"eks.amazonaws.com/nodegroup=gpu",
+		"workload=gpu",
+	} {
+		if !strings.Contains(string(userData), want) {
+			t.Errorf("user data should contain %q, got %s", want, userData)
+		}
+	}
+	devices := template.Inputs["blockDeviceMappings"].ArrayValue()
+	if len(devices) != 1 || devices[0].ObjectValue()["ebs"].ObjectValue()["volumeSize"].NumberValue() != 100 {
+		t.Errorf("tainted group disk size should be set in the template, got %v", devices)
+	}
+
+	gpu, ok := m.Resource("aws:eks/nodeGroup:NodeGroup", "gpu")
+	if !ok {
+		t.Fatal("node group gpu was not created")
+	}
+	if _, ok := gpu.Inputs["diskSize"]; ok {
+		t.Error("a node group with a launch template can't set a disk size")
+	}
+	if version := gpu.Inputs["launchTemplate"].ObjectValue()["version"].StringValue(); version != "1" {
+		t.Errorf("gpu launch template version = %s, want 1", version)
+	}
+}
+
+func TestNodeGroupsInvalidConfig(t *testing.T) {
+	for name, groups := range map[string]string{
+		"unnamed":        `[{"minSize": 1, "desiredSize": 1, "maxSize": 1}]`,
+		"duplicate":      `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1}, {"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1}]`,
+		"scaling":        `[{"name": "a", "minSize": 3, "desiredSize": 2, "maxSize": 4}]`,
+		"capacity type":  `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "capacityType": "RESERVED"}]`,
+		"taint effect":   `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "taints": [{"key": "a", "effect": "NoWay"}]}]`,
+		"taint key":      `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "taints": [{"effect": "NoSchedule"}]}]`,
+		"taint template": `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "taints": [{"key": "a", "effect": "NoSchedule"}], "launchTemplate": {"id": "lt-a", "version": "1"}}]`,
+		"taint AMI type": `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "taints": [{"key": "a", "effect": "NoSchedule"}], "amiType": "AL2_ARM_64"}]`,
+		"template disk":  `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "diskSize": 50, "launchTemplate": {"id": "lt-a", "version": "1"}}]`,
+		"template id":    `[{"name": "a", "minSize": 1, "desiredSize": 1, "maxSize": 1, "launchTemplate": {"version": "1"}}]`,
+	} {
+		m := newMocks()
+		err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+			"eks.go:nodeGroups": groups,
+		}))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEbsCsiRole(t *testing.T) {
+	m := newMocks().SetResourceOutputs("aws:iam/role:Role", map[string]interface{}{
+		"arn": "arn:aws:iam::123456789012:role/ebs-csi-driver",
+	})
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	role, ok := m.Resource("aws:iam/role:Role", "ebs-csi-driver")
+	if !ok {
+		t.Fatal("EBS CSI driver role was not created")
+	}
+	if trust := role.Inputs["assumeRolePolicy"].StringValue(); !strings.Contains(trust, ebsCsiServiceAccount) {
+		t.Errorf("EBS CSI driver role should only trust %s, got %s", ebsCsiServiceAccount, trust)
+	}
+
+	attachment, ok := m.Resource("aws:iam/rolePolicyAttachment:RolePolicyAttachment", "ebs-csi-driver")
+	if !ok {
+		t.Fatal("EBS CSI driver policy was not attached")
+	}
+	if arn := attachment.Inputs["policyArn"].StringValue(); arn != "arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy" {
+		t.Errorf("EBS CSI driver policy = %s", arn)
+	}
+
+	addon, ok := m.Resource("aws:eks/addon:Addon", ebsCsiAddon)
+	if !ok {
+		t.Fatal("EBS CSI driver add-on was not created")
+	}
+	if arn := addon.Inputs["serviceAccountRoleArn"].StringValue(); arn != "arn:aws:iam::123456789012:role/ebs-csi-driver" {
+		t.Errorf("EBS CSI driver add-on should use its role, got %s", arn)
+	}
+}
+
+func TestAddons(t *testing.T) {
+	m := newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:kubernetesVersion": "1.19",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range defaultAddons["1.19"] {
+		addon, ok := m.Resource("aws:eks/addon:Addon", name)
+		if !ok {
+			t.Errorf("add-on %s was not created", name)
+			continue
+		}
+		if addon.Inputs["clusterName"].StringValue() != clusterName || addon.Inputs["addonName"].StringValue() != name {
+			t.Errorf("add-on %s = %v, want it on cluster %s", name, addon.Inputs, clusterName)
+		}
+		if version := addon.Inputs["addonVersion"].StringValue(); version != want.Version {
+			t.Errorf("add-on %s version = %s, want %s", name, version, want.Version)
+		}
+		if resolve := addon.Inputs["resolveConflicts"].StringValue(); resolve != want.ResolveConflicts {
+			t.Errorf("add-on %s resolveConflicts = %s, want %s", name, resolve, want.ResolveConflicts)
+		}
+		if _, ok := addon.Inputs["serviceAccountRoleArn"]; ok && name != ebsCsiAddon {
+			t.Errorf("add-on %s should use the node role", name)
+		}
+	}
+}
+
+func TestDefaultAddonVersions(t *testing.T) {
+	// coredns releases are built for these Kubernetes versions
+	coredns := map[string]string{
+		"1.18": "v1.7.",
+		"1.19": "v1.8.0-",
+		"1.20": "v1.8.3-",
+	}
+
+	for version, addons := range defaultAddons {
+		for _, name := range []string{"vpc-cni", "coredns", "kube-proxy", ebsCsiAddon} {
+			if _, ok := addons[name]; !ok {
+				t.Errorf("Kubernetes %s has no default %s version", version, name)
+			}
+		}
+		if err := validateAddons(addons); err != nil {
+			t.Errorf("Kubernetes %s: %v", version, err)
+		}
+		if proxy := addons["kube-proxy"].Version; !strings.HasPrefix(proxy, "v"+version+".") {
+			t.Errorf("Kubernetes %s has kube-proxy %s, built for another version", version, proxy)
+		}
+		if dns, want := addons["coredns"].Version, coredns[version]; want == "" || !strings.HasPrefix(dns, want) {
+			t.Errorf("Kubernetes %s has coredns %s, want %s", version, dns, want)
+		}
+	}
+}
+
+func TestAddonsUnknownVersion(t *testing.T) {
+	err := newMocks().Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:kubernetesVersion": "1.15",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "no default add-on versions for Kubernetes 1.15") {
+		t.Errorf("expected a missing add-on versions error, got %v", err)
+	}
+
+	err = newMocks().Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:kubernetesVersion": "1.15",
+		"eks.go:addons":            `{"vpc-cni": {"version": "v1.7.5-eksbuild.1", "resolveConflicts": "NONE"}}`,
+	}))
+	if err != nil {
+		t.Errorf("configured add-ons should work on any version, got %v", err)
+	}
+}
+
+func TestAddonsConfig(t *testing.T) {
+	m := newMocks()
+	err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+		"eks.go:addons": `{"vpc-cni": {"version": "v1.7.10-eksbuild.1", "resolveConflicts": "NONE"}}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Resource("aws:iam/role:Role", "ebs-csi-driver"); ok {
+		t.Error("EBS CSI driver role should only be created with the add-on")
+	}
+	if n := len(m.Resources("aws:eks/addon:Addon")); n != 1 {
+		t.Errorf("only the configured add-on should be created, got %d", n)
+	}
+	vpcCni, _ := m.Resource("aws:eks/addon:Addon", "vpc-cni")
+	if version := vpcCni.Inputs["addonVersion"].StringValue(); version != "v1.7.10-eksbuild.1" {
+		t.Errorf("vpc-cni version = %s, want v1.7.10-eksbuild.1", version)
+	}
+
+	for name, addons := range map[string]string{
+		"version":           `{"coredns": {"resolveConflicts": "NONE"}}`,
+		"resolve conflicts": `{"coredns": {"version": "v1.8.0-eksbuild.1", "resolveConflicts": "REPLACE"}}`,
+		"preserve":          `{"coredns": {"version": "v1.8.0-eksbuild.1", "resolveConflicts": "PRESERVE"}}`,
+	} {
+		m := newMocks()
+		err := m.Run("eks.go", createStack, mocks.Config(map[string]string{
+			"eks.go:addons": addons,
+		}))
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestClusterRoles(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	attached := map[string]bool{}
+	for _, r := range m.Resources("aws:iam/rolePolicyAttachment:RolePolicyAttachment") {
+		attached[r.Inputs["policyArn"].StringValue()] = true
+	}
+	for _, arn := range []string{
+		"arn:aws:iam::aws:policy/AmazonEKSClusterPolicy",
+		"arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy",
+		"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy",
+		"arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly",
+	} {
+		if !attached[arn] {
+			t.Errorf("policy %s is not attached", arn)
+		}
+	}
+}
+
+func TestOidcProvider(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, ok := m.Resource("aws:iam/openIdConnectProvider:OpenIdConnectProvider", "oidc-provider")
+	if !ok {
+		t.Fatal("OIDC provider was not created")
+	}
+	if url := provider.Inputs["url"].StringValue(); url != issuer {
+		t.Errorf("OIDC provider url = %s, want %s", url, issuer)
+	}
+	if clients := provider.Inputs["clientIdLists"].ArrayValue(); len(clients) != 1 || clients[0].StringValue() != "sts.amazonaws.com" {
+		t.Errorf("OIDC provider should only allow sts.amazonaws.com, got %v", clients)
+	}
+	if thumbprints := provider.Inputs["thumbprintLists"].ArrayValue(); len(thumbprints) != 1 || thumbprints[0].StringValue() != "9e99a48a9960b14926bb7f3b02e22da2b0ab7280" {
+		t.Errorf("OIDC provider should use the issuer's thumbprint, got %v", thumbprints)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	issuerRootCAs = x509.NewCertPool()
+	issuerRootCAs.AddCert(server.Certificate())
+	defer func() { issuerRootCAs = nil }()
+
+	got, err := thumbprint(server.URL + "/id/0123456789ABCDEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha1.Sum(server.Certificate().Raw)
+	if want := hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("thumbprint() = %s, want %s", got, want)
+	}
+
+	if _, err := thumbprint("http://oidc.example.com"); err == nil {
+		t.Error("expected an error for a non-https issuer")
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("eks.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// nodeGroupConfig is an entry in the eks.go:nodeGroups config list
+type nodeGroupConfig struct {
+	Name           string                `json:"name"`
+	InstanceTypes  []string              `json:"instanceTypes"`
+	CapacityType   string                `json:"capacityType"`
+	DiskSize       int                   `json:"diskSize"`
+	AmiType        string                `json:"amiType"`
+	Labels         map[string]string     `json:"labels"`
+	Taints         []taintConfig         `json:"taints"`
+	LaunchTemplate *launchTemplateConfig `json:"launchTemplate"`
+	MinSize        int                   `json:"minSize"`
+	MaxSize        int                   `json:"maxSize"`
+	DesiredSize    int                   `json:"desiredSize"`
+}
+
+// taintConfig is a taint every node in a group registers with
+type taintConfig struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Effect string `json:"effect"`
+}
+
+// String formats the taint the way the kubelet's --register-with-taints takes it
+func (t taintConfig) String() string {
+	if t.Value == "" {
+		return t.Key + ":" + t.Effect
+	}
+	return t.Key + "=" + t.Value + ":" + t.Effect
+}
+
+// launchTemplateConfig is an existing launch template a node group's
+// instances are created from
+type launchTemplateConfig struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
+
+// defaultNodeGroups is the single node group created when none are configured
+var defaultNodeGroups = []nodeGroupConfig{
+	{
+		Name:        "node-group",
+		MinSize:     1,
+		MaxSize:     2,
+		DesiredSize: 2,
+	},
+}
+
+// validateNodeGroups checks every node group is named once and can be scaled,
+// and that its options can be used together
+func validateNodeGroups(groups []nodeGroupConfig) error {
+	seen := map[string]bool{}
+	for i, group := range groups {
+		if group.Name == "" {
+			return fmt.Errorf("node group %d has no name", i)
+		}
+		if seen[group.Name] {
+			return fmt.Errorf("node group %q is configured more than once", group.Name)
+		}
+		seen[group.Name] = true
+
+		switch group.CapacityType {
+		case "", "ON_DEMAND", "SPOT":
+		default:
+			return fmt.Errorf("node group %q has capacityType %q, must be ON_DEMAND or SPOT", group.Name, group.CapacityType)
+		}
+		if group.DiskSize < 0 {
+			return fmt.Errorf("node group %q has a negative disk size", group.Name)
+		}
+		if group.MinSize < 0 || group.MaxSize < 1 || group.MinSize > group.DesiredSize || group.DesiredSize > group.MaxSize {
+			return fmt.Errorf("node group %q must have 0 <= minSize <= desiredSize <= maxSize and maxSize >= 1, got %d, %d and %d",
+				group.Name, group.MinSize, group.DesiredSize, group.MaxSize)
+		}
+
+		for _, taint := range group.Taints {
+			if taint.Key == "" {
+				return fmt.Errorf("node group %q has a taint without a key", group.Name)
+			}
+			switch taint.Effect {
+			case "NoSchedule", "PreferNoSchedule", "NoExecute":
+			default:
+				return fmt.Errorf("node group %q taint %q has effect %q, must be NoSchedule, PreferNoSchedule or NoExecute",
+					group.Name, taint.Key, taint.Effect)
+			}
+		}
+
+		if template := group.LaunchTemplate; template != nil {
+			if template.ID == "" || template.Version == "" {
+				return fmt.Errorf("node group %q launch template needs an id and a version", group.Name)
+			}
+			if group.DiskSize > 0 {
+				return fmt.Errorf("node group %q sets a diskSize and a launch template, set the disk size in the template", group.Name)
+			}
+		}
+
+		// taints are registered by the bootstrap script in a template this program creates
+		if len(group.Taints) > 0 {
+			if group.LaunchTemplate != nil {
+				return fmt.Errorf("node group %q sets taints and a launch template, add the taints to the template's user data", group.Name)
+			}
+			if group.AmiType != "" {
+				return fmt.Errorf("node group %q sets taints and an amiType, tainted groups run the EKS optimized AMI", group.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// newNodeGroups creates a managed node group for each config entry, and
+// returns them so add-ons which run on the nodes can wait for them. Groups
+// with taints get a launch template which runs the EKS optimized AMI for the
+// cluster's Kubernetes version, and registers the taints when the node
+// bootstraps
+func newNodeGroups(ctx *pulumi.Context, cluster *eks.Cluster, version string, nodeRoleArn pulumi.StringInput, subnetIDs pulumi.StringArrayInput,
+	groups []nodeGroupConfig, opts ...pulumi.ResourceOption) ([]pulumi.Resource, error) {
+	if err := validateNodeGroups(groups); err != nil {
+		return nil, err
+	}
+
+	var nodeGroups []pulumi.Resource
+	for _, group := range groups {
+		groupOpts := append([]pulumi.ResourceOption{pulumi.Parent(cluster)}, opts...)
+
+		args := &eks.NodeGroupArgs{
+			ClusterName:   cluster.Name,
+			NodeGroupName: pulumi.String(group.Name),
+			NodeRoleArn:   nodeRoleArn,
+			SubnetIds:     subnetIDs,
+			ScalingConfig: &eks.NodeGroupScalingConfigArgs{
+				DesiredSize: pulumi.Int(group.DesiredSize),
+				MaxSize:     pulumi.Int(group.MaxSize),
+				MinSize:     pulumi.Int(group.MinSize),
+			},
+		}
+		if len(group.InstanceTypes) > 0 {
+			args.InstanceTypes = pulumi.ToStringArray(group.InstanceTypes)
+		}
+		if group.CapacityType != "" {
+			args.CapacityType = pulumi.String(group.CapacityType)
+		}
+		if group.AmiType != "" {
+			args.AmiType = pulumi.String(group.AmiType)
+		}
+		if len(group.Labels) > 0 {
+			args.Labels = pulumi.ToStringMap(group.Labels)
+		}
+
+		switch {
+		case group.LaunchTemplate != nil:
+			args.LaunchTemplate = &eks.NodeGroupLaunchTemplateArgs{
+				Id:      pulumi.String(group.LaunchTemplate.ID),
+				Version: pulumi.String(group.LaunchTemplate.Version),
+			}
+		case len(group.Taints) > 0:
+			template, err := newTaintedLaunchTemplate(ctx, cluster, version, group, groupOpts...)
+			if err != nil {
+				return nil, err
+			}
+			args.LaunchTemplate = &eks.NodeGroupLaunchTemplateArgs{
+				Id:      template.ID(),
+				Version: pulumi.Sprintf("%d", template.LatestVersion),
+			}
+		case group.DiskSize > 0:
+			args.DiskSize = pulumi.Int(group.DiskSize)
+		}
+
+		// desiredSize is only the initial size, after that the cluster autoscaler owns it
+		nodeGroupOpts := append([]pulumi.ResourceOption{pulumi.IgnoreChanges([]string{"scalingConfig.desiredSize"})}, groupOpts...)
+		nodeGroup, err := eks.NewNodeGroup(ctx, group.Name, args, nodeGroupOpts...)
+		if err != nil {
+			return nil, err
+		}
+		nodeGroups = append(nodeGroups, nodeGroup)
+	}
+
+	return nodeGroups, nil
+}
+
+// newTaintedLaunchTemplate creates a launch template for a node group with
+// taints. Managed node groups can't taint their nodes, so the template runs
+// the EKS optimized AMI for the Kubernetes version with user data which
+// passes the taints and labels to the kubelet
+func newTaintedLaunchTemplate(ctx *pulumi.Context, cluster *eks.Cluster, version string, group nodeGroupConfig,
+	opts ...pulumi.ResourceOption) (*ec2.LaunchTemplate, error) {
+	ami, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
+		Name: fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/image_id", version),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the EKS optimized AMI for %s: %w", version, err)
+	}
+
+	kubeletArgs := fmt.Sprintf("--node-labels=%s --register-with-taints=%s", nodeLabels(group), nodeTaints(group))
+	userData := pulumi.All(cluster.Name, cluster.Endpoint, cluster.CertificateAuthority.Data().Elem()).ApplyT(func(args []interface{}) string {
+		script := fmt.Sprintf("#!/bin/bash\nset -o xtrace\n/etc/eks/bootstrap.sh %s --apiserver-endpoint '%s' --b64-cluster-ca '%s' --kubelet-extra-args '%s'\n",
+			args[0].(string), args[1].(string), args[2].(string), kubeletArgs)
+		return base64.StdEncoding.EncodeToString([]byte(script))
+	}).(pulumi.StringOutput)
+
+	args := &ec2.LaunchTemplateArgs{
+		ImageId:  pulumi.String(ami.Value),
+		UserData: userData,
+	}
+	if group.DiskSize > 0 {
+		args.BlockDeviceMappings = ec2.LaunchTemplateBlockDeviceMappingArray{
+			ec2.LaunchTemplateBlockDeviceMappingArgs{
+				DeviceName: pulumi.String("/dev/xvda"),
+				Ebs: &ec2.LaunchTemplateBlockDeviceMappingEbsArgs{
+					VolumeSize: pulumi.Int(group.DiskSize),
+					VolumeType: pulumi.String("gp2"),
+				},
+			},
+		}
+	}
+
+	return ec2.NewLaunchTemplate(ctx, group.Name, args, opts...)
+}
+
+// nodeLabels returns the labels a node in the group registers with, in the
+// kubelet's --node-labels format. EKS only adds its own labels to nodes it
+// bootstraps, so they're added here
+func nodeLabels(group nodeGroupConfig) string {
+	capacityType := group.CapacityType
+	if capacityType == "" {
+		capacityType = "ON_DEMAND"
+	}
+
+	labels := []string{
+		"eks.amazonaws.com/nodegroup=" + group.Name,
+		"eks.amazonaws.com/capacityType=" + capacityType,
+	}
+	for k, v := range group.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ",")
+}
+
+// nodeTaints returns the group's taints in the kubelet's
+// --register-with-taints format
+func nodeTaints(group nodeGroupConfig) string {
+	taints := make([]string, 0, len(group.Taints))
+	for _, taint := range group.Taints {
+		taints = append(taints, taint.String())
+	}
+	return strings.Join(taints, ",")
+}
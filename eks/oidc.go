@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// issuerRootCAs verifies the OIDC issuer's certificate. nil uses the
+// system's roots
+var issuerRootCAs *x509.CertPool
+
+// thumbprint returns the SHA1 fingerprint of the root certificate the OIDC
+// issuer at the given URL presents, which IAM uses to trust the issuer
+func thumbprint(issuer string) (string, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("OIDC issuer %q is not an https URL", issuer)
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", address, &tls.Config{
+		ServerName: u.Hostname(),
+		RootCAs:    issuerRootCAs,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error connecting to OIDC issuer %q: %w", issuer, err)
+	}
+	defer conn.Close()
+
+	chains := conn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", fmt.Errorf("OIDC issuer %q presented no certificates", issuer)
+	}
+
+	root := chains[0][len(chains[0])-1]
+	sum := sha1.Sum(root.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// clusterThumbprint computes the thumbprint for an issuer, and is replaced in
+// tests so they don't need the network
+var clusterThumbprint = thumbprint
+
+// newOidcProvider creates an IAM OIDC provider for the cluster's identity
+// issuer, so service accounts can assume IAM roles
+func newOidcProvider(ctx *pulumi.Context, cluster *eks.Cluster) (*iam.OpenIdConnectProvider, pulumi.StringOutput, error) {
+	issuer := cluster.Identities.ApplyT(func(identities []eks.ClusterIdentity) (string, error) {
+		if len(identities) == 0 || len(identities[0].Oidcs) == 0 || identities[0].Oidcs[0].Issuer == nil {
+			return "", errors.New("cluster has no OIDC issuer")
+		}
+		return *identities[0].Oidcs[0].Issuer, nil
+	}).(pulumi.StringOutput)
+
+	provider, err := iam.NewOpenIdConnectProvider(ctx, "oidc-provider", &iam.OpenIdConnectProviderArgs{
+		Url: issuer,
+		ClientIdLists: pulumi.StringArray{
+			pulumi.String("sts.amazonaws.com"),
+		},
+		ThumbprintLists: pulumi.StringArray{
+			issuer.ApplyT(func(issuer string) (string, error) {
+				return clusterThumbprint(issuer)
+			}).(pulumi.StringOutput),
+		},
+	}, pulumi.Parent(cluster))
+	if err != nil {
+		return nil, pulumi.StringOutput{}, err
+	}
+
+	return provider, issuer, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v3/go/aws/kms"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// newSecretsEncryption creates a KMS key, rotated every year, which the
+// cluster uses to encrypt Kubernetes secrets, and lets the cluster's role use it
+func newSecretsEncryption(ctx *pulumi.Context, clusterName string, clusterRole *iam.Role) (*eks.ClusterEncryptionConfigArgs, error) {
+	key, err := kms.NewKey(ctx, "eks-secrets", &kms.KeyArgs{
+		Description:       pulumi.String("Encrypts secrets in the " + clusterName + " EKS cluster"),
+		EnableKeyRotation: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keyPolicyJSON, err := policy.Document{
+		Statement: []policy.Statement{
+			{
+				Effect:   policy.Allow,
+				Action:   []string{"kms:Encrypt", "kms:Decrypt", "kms:ListGrants", "kms:DescribeKey"},
+				Resource: []pulumi.StringInput{key.Arn},
+			},
+		},
+	}.Render()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, "eks-secrets", &iam.RolePolicyArgs{
+		Role:   clusterRole.Name,
+		Policy: keyPolicyJSON,
+	}, pulumi.Parent(clusterRole))
+	if err != nil {
+		return nil, err
+	}
+
+	return &eks.ClusterEncryptionConfigArgs{
+		Provider: &eks.ClusterEncryptionConfigProviderArgs{
+			KeyArn: key.Arn,
+		},
+		Resources: pulumi.StringArray{pulumi.String("secrets")},
+	}, nil
+}
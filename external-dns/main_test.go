@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestServiceAccountRole(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("external-dns.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	role, ok := m.Resource("aws:iam/role:Role", "external-dns")
+	if !ok {
+		t.Fatal("external-dns role was not created")
+	}
+
+	var policy struct {
+		Statement []struct {
+			Action    string
+			Condition map[string]map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(role.Inputs["assumeRolePolicy"].StringValue()), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 1 || policy.Statement[0].Action != "sts:AssumeRoleWithWebIdentity" {
+		t.Fatalf("external-dns role should only be assumable by web identity, got %+v", policy.Statement)
+	}
+
+	found := false
+	for _, sub := range policy.Statement[0].Condition["StringEquals"] {
+		found = found || sub == "system:serviceaccount:external-dns:external-dns"
+	}
+	if !found {
+		t.Errorf("external-dns role should be scoped to its service account, got %v", policy.Statement[0].Condition)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("external-dns.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("kubernetes:core/v1:Namespace", "external-dns"); !ok {
+		t.Error("external-dns namespace was not created")
+	}
+}
@@ -3,8 +3,11 @@ module grafana.vpc
 go 1.14
 
 require (
-	github.com/pulumi/pulumi-aws/sdk/v2 v2.0.0
+	github.com/jaxxstorm/iac-in-go/lib v0.0.0
+	github.com/pulumi/pulumi-aws/sdk/v2 v2.12.0
 	github.com/pulumi/pulumi-mysql/sdk/v2 v2.1.3
 	github.com/pulumi/pulumi-random/sdk/v2 v2.2.0
-	github.com/pulumi/pulumi/sdk/v2 v2.0.0
+	github.com/pulumi/pulumi/sdk/v2 v2.6.1
 )
+
+replace github.com/jaxxstorm/iac-in-go/lib => ../lib
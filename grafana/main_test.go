@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestService(t *testing.T) {
+	m := mocks.Default().SetCallResult("aws:route53/getZone:getZone", map[string]interface{}{
+		"zoneId": "Z0123456789PUBLIC",
+	})
+	if err := m.Run("grafana.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	service, ok := m.Resource("aws:ecs/service:Service", "grafana")
+	if !ok {
+		t.Fatal("grafana service was not created")
+	}
+	network := service.Inputs["networkConfiguration"].ObjectValue()
+	if network["assignPublicIp"].BoolValue() {
+		t.Error("grafana tasks should not have public IPs")
+	}
+	for _, subnet := range network["subnets"].ArrayValue() {
+		if !strings.HasPrefix(subnet.StringValue(), "subnet-private") {
+			t.Errorf("grafana tasks should only run in private subnets, got %s", subnet.StringValue())
+		}
+	}
+
+	targetGroup, ok := m.Resource("aws:lb/targetGroup:TargetGroup", "grafana")
+	if !ok {
+		t.Fatal("grafana target group was not created")
+	}
+	if path := targetGroup.Inputs["healthCheck"].ObjectValue()["path"].StringValue(); path != "/api/health" {
+		t.Errorf("grafana target group should check /api/health, got %s", path)
+	}
+
+	record, ok := m.Resource("aws:route53/record:Record", "grafana")
+	if !ok {
+		t.Fatal("grafana record was not created")
+	}
+	if target := record.Inputs["records"].ArrayValue()[0].StringValue(); target != "web-123456789.us-west-2.elb.amazonaws.com" {
+		t.Errorf("grafana record should point at the load balancer, got %s", target)
+	}
+	if zone := record.Inputs["zoneId"].StringValue(); zone != "Z0123456789PUBLIC" {
+		t.Errorf("grafana record should be created in the looked up zone, got %s", zone)
+	}
+}
+
+func TestSecurityGroup(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("grafana.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	sg, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "grafana")
+	if !ok {
+		t.Fatal("grafana security group was not created")
+	}
+	vpcAllowed := false
+	for _, rule := range sg.Ingress() {
+		if rule.Allows("0.0.0.0/0", 3000) {
+			t.Errorf("grafana security group opens 3000 to the world: %+v", rule)
+		}
+		vpcAllowed = vpcAllowed || rule.Allows("172.1.0.0/16", 3000)
+	}
+	if !vpcAllowed {
+		t.Error("grafana security group should allow 3000 from the VPC")
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("grafana.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}
@@ -3,6 +3,9 @@ module kong.go
 go 1.14
 
 require (
+	github.com/jaxxstorm/iac-in-go/lib v0.0.0
 	github.com/pulumi/pulumi-kubernetes/sdk/v2 v2.4.0
-	github.com/pulumi/pulumi/sdk/v2 v2.2.2-0.20200514204320-e677c7d6dca3
+	github.com/pulumi/pulumi/sdk/v2 v2.6.1
 )
+
+replace github.com/jaxxstorm/iac-in-go/lib => ../lib
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestKonga(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("kong.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Resource("kubernetes:core/v1:Namespace", "kong"); !ok {
+		t.Error("kong namespace was not created")
+	}
+
+	ingress, ok := m.Resource("kubernetes:networking.k8s.io/v1beta1:Ingress", "konga-ingress")
+	if !ok {
+		t.Fatal("konga ingress was not created")
+	}
+	rules := ingress.Inputs["spec"].ObjectValue()["rules"].ArrayValue()
+	if host := rules[0].ObjectValue()["host"].StringValue(); host != "konga.aws.briggs.work" {
+		t.Errorf("unexpected konga host %s", host)
+	}
+}
@@ -0,0 +1,91 @@
+// Package awsprovider reads the account and region a stack's AWS resources are
+// created in. Every resource uses the default AWS provider, configured with
+// aws:region, aws:profile and aws:assumeRole, so the same programs can deploy
+// to any account and region. The package doesn't import pulumi-aws, so it
+// works with programs on any major version of it
+package awsprovider
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi/config"
+)
+
+// Config is where and as whom a stack's AWS resources are created, as read by
+// the default AWS provider
+type Config struct {
+	// Region is aws:region, falling back to the AWS_REGION and
+	// AWS_DEFAULT_REGION environment variables like the provider does
+	Region string
+
+	// Profile is the named profile in the shared credentials file, aws:profile
+	Profile string
+
+	// AssumeRoleArn is a role to assume in the target account,
+	// aws:assumeRole.roleArn
+	AssumeRoleArn string
+}
+
+// assumeRole is the part of the provider's aws:assumeRole object read by Get
+type assumeRole struct {
+	RoleArn string `json:"roleArn"`
+}
+
+// Get reads the default AWS provider's config for the current stack
+func Get(ctx *pulumi.Context) (Config, error) {
+	conf := Config{
+		Region:  config.Get(ctx, "aws:region"),
+		Profile: config.Get(ctx, "aws:profile"),
+	}
+	if conf.Region == "" {
+		conf.Region = os.Getenv("AWS_REGION")
+	}
+	if conf.Region == "" {
+		conf.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	var role assumeRole
+	if err := config.GetObject(ctx, "aws:assumeRole", &role); err != nil {
+		return Config{}, fmt.Errorf("error reading aws:assumeRole: %w", err)
+	}
+	conf.AssumeRoleArn = role.RoleArn
+
+	return conf, nil
+}
+
+// Account is the AWS account and region a stack deploys to
+type Account struct {
+	ID     string
+	Region string
+}
+
+// callerIdentity is the part of the result of aws.GetCallerIdentity read by
+// Lookup
+type callerIdentity struct {
+	AccountID string `pulumi:"accountId"`
+}
+
+// Lookup reads the region from the stack's config, and looks up the account
+// the default AWS provider's credentials belong to
+func Lookup(ctx *pulumi.Context) (*Account, error) {
+	conf, err := Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Region == "" {
+		return nil, errors.New("no AWS region is configured, set aws:region")
+	}
+
+	var identity callerIdentity
+	if err := ctx.Invoke("aws:index/getCallerIdentity:getCallerIdentity", nil, &identity); err != nil {
+		return nil, fmt.Errorf("error looking up the AWS account: %w", err)
+	}
+
+	return &Account{
+		ID:     identity.AccountID,
+		Region: conf.Region,
+	}, nil
+}
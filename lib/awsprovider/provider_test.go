@@ -0,0 +1,166 @@
+package awsprovider
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+	"github.com/jaxxstorm/iac-in-go/lib/tags"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+type vpc struct {
+	pulumi.CustomResourceState
+}
+
+type vpcArgs struct {
+	CidrBlock pulumi.StringInput `pulumi:"cidrBlock"`
+	Tags      pulumi.MapInput    `pulumi:"tags"`
+}
+
+type vpcInputs struct {
+	CidrBlock string                 `pulumi:"cidrBlock"`
+	Tags      map[string]interface{} `pulumi:"tags"`
+}
+
+func (vpcArgs) ElementType() reflect.Type { return reflect.TypeOf((*vpcInputs)(nil)).Elem() }
+
+// setenv sets an environment variable for the rest of the test
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestGet(t *testing.T) {
+	setenv(t, "AWS_REGION", "")
+	setenv(t, "AWS_DEFAULT_REGION", "")
+
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   Config
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:   "aws region",
+			config: map[string]string{"aws:region": "eu-west-1"},
+			want:   Config{Region: "eu-west-1"},
+		},
+		{
+			name: "another account",
+			config: map[string]string{
+				"aws:region":     "ap-southeast-2",
+				"aws:profile":    "staging",
+				"aws:assumeRole": `{"roleArn": "arn:aws:iam::210987654321:role/deploy", "sessionName": "ci"}`,
+			},
+			want: Config{
+				Region:        "ap-southeast-2",
+				Profile:       "staging",
+				AssumeRoleArn: "arn:aws:iam::210987654321:role/deploy",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Config
+			err := mocks.New().SetConfig("aws:region", "").Run("eks.go", func(ctx *pulumi.Context) (err error) {
+				got, err = Get(ctx)
+				return err
+			}, mocks.Config(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	var account *Account
+	err := mocks.New().Run("eks.go", func(ctx *pulumi.Context) (err error) {
+		account, err = Lookup(ctx)
+		return err
+	}, mocks.Config(map[string]string{"aws:region": "eu-west-1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Region != "eu-west-1" || account.ID != mocks.AccountID {
+		t.Errorf("expected eu-west-1 in %s, got %s in %s", mocks.AccountID, account.Region, account.ID)
+	}
+}
+
+func TestLookupRegionFromEnvironment(t *testing.T) {
+	setenv(t, "AWS_REGION", "")
+	setenv(t, "AWS_DEFAULT_REGION", "ca-central-1")
+
+	var account *Account
+	err := mocks.New().SetConfig("aws:region", "").Run("eks.go", func(ctx *pulumi.Context) (err error) {
+		account, err = Lookup(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Region != "ca-central-1" {
+		t.Errorf("expected the region from the environment, got %q", account.Region)
+	}
+}
+
+func TestLookupWithoutRegion(t *testing.T) {
+	setenv(t, "AWS_REGION", "")
+	setenv(t, "AWS_DEFAULT_REGION", "")
+
+	err := mocks.New().SetConfig("aws:region", "").Run("eks.go", func(ctx *pulumi.Context) error {
+		_, err := Lookup(ctx)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "no AWS region is configured") {
+		t.Errorf("expected a missing region error, got %v", err)
+	}
+}
+
+func TestLookupKeepsDefaultProvider(t *testing.T) {
+	m := mocks.New()
+	err := m.Run("vpc.go", func(ctx *pulumi.Context) error {
+		if err := tags.Register(ctx); err != nil {
+			return err
+		}
+		if _, err := Lookup(ctx); err != nil {
+			return err
+		}
+		return ctx.RegisterResource("aws:ec2/vpc:Vpc", "vpc", &vpcArgs{CidrBlock: pulumi.String("10.0.0.0/16")}, &vpc{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if providers := m.Resources("pulumi:providers:aws"); len(providers) != 0 {
+		t.Errorf("no AWS provider should be created, got %d", len(providers))
+	}
+	vpc, ok := m.Resource("aws:ec2/vpc:Vpc", "vpc")
+	if !ok {
+		t.Fatal("vpc was not created")
+	}
+	if vpc.Provider != "" {
+		t.Errorf("vpc should be created with the default provider, got %q", vpc.Provider)
+	}
+	if vpc.Tags()["Owner"] == "" {
+		t.Error("vpc should still be tagged")
+	}
+}
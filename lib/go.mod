@@ -0,0 +1,9 @@
+module github.com/jaxxstorm/iac-in-go/lib
+
+go 1.14
+
+require (
+	github.com/pulumi/pulumi-aws/sdk/v2 v2.12.0
+	github.com/pulumi/pulumi-kubernetes/sdk/v2 v2.4.0
+	github.com/pulumi/pulumi/sdk/v2 v2.6.1
+)
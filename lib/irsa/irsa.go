@@ -0,0 +1,134 @@
+// Package irsa creates IAM roles for Kubernetes service accounts (IRSA), which
+// are trusted by the EKS cluster's OIDC provider
+package irsa
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/iam"
+	corev1 "github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/core/v1"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v2/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// RoleArnAnnotation is the service account annotation EKS reads the role from
+const RoleArnAnnotation = "eks.amazonaws.com/role-arn"
+
+// Policies are the permissions granted to a service account's role
+type Policies struct {
+	// Managed are the ARNs of managed policies attached to the role
+	Managed []pulumi.StringInput
+
+	// Inline are policy documents added to the role, keyed by name
+	Inline map[string]policy.Document
+}
+
+// ServiceAccountRole is an IAM role which can only be assumed by a single
+// Kubernetes service account
+type ServiceAccountRole struct {
+	pulumi.ResourceState
+
+	Namespace      string
+	ServiceAccount string
+
+	Role *iam.Role
+	Arn  pulumi.StringOutput
+
+	name string
+}
+
+// NewServiceAccountRole creates a role for the service account in the given
+// namespace, trusted by the OIDC provider exported by the eks stack
+func NewServiceAccountRole(ctx *pulumi.Context, name, namespace, serviceAccount string, policies Policies, opts ...pulumi.ResourceOption) (*ServiceAccountRole, error) {
+	cluster, err := stackref.Eks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ServiceAccountRole{
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+		name:           name,
+	}
+	if err := ctx.RegisterComponentResource("iac:irsa:ServiceAccountRole", name, r, opts...); err != nil {
+		return nil, err
+	}
+
+	/*
+	 * Only tokens for this service account,
+	 * issued for STS, can assume the role
+	 */
+	assumeRolePolicyJSON, err := policy.WebIdentityAssumeRole(
+		cluster.OidcProviderArn,
+		fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount),
+	).Render()
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := iam.NewRole(ctx, name, &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicyJSON,
+	}, pulumi.Parent(r))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, policyArn := range policies.Managed {
+		_, err := iam.NewRolePolicyAttachment(ctx, fmt.Sprintf("%s-%d", name, i), &iam.RolePolicyAttachmentArgs{
+			Role:      role.Name,
+			PolicyArn: policyArn,
+		}, pulumi.Parent(role))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// add the inline policies in a stable order
+	inline := make([]string, 0, len(policies.Inline))
+	for policyName := range policies.Inline {
+		inline = append(inline, policyName)
+	}
+	sort.Strings(inline)
+
+	for _, policyName := range inline {
+		policyJSON, err := policies.Inline[policyName].Render()
+		if err != nil {
+			return nil, fmt.Errorf("inline policy %q: %w", policyName, err)
+		}
+
+		_, err = iam.NewRolePolicy(ctx, fmt.Sprintf("%s-%s", name, policyName), &iam.RolePolicyArgs{
+			Role:   role.Name,
+			Policy: policyJSON,
+		}, pulumi.Parent(role))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.Role = role
+	r.Arn = role.Arn
+
+	if err := ctx.RegisterResourceOutputs(r, pulumi.Map{"arn": role.Arn}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewServiceAccount creates the Kubernetes service account, annotated with the
+// role's ARN. Pass the Kubernetes provider for the cluster in opts
+func (r *ServiceAccountRole) NewServiceAccount(ctx *pulumi.Context, opts ...pulumi.ResourceOption) (*corev1.ServiceAccount, error) {
+	opts = append([]pulumi.ResourceOption{pulumi.Parent(r)}, opts...)
+
+	return corev1.NewServiceAccount(ctx, r.name, &corev1.ServiceAccountArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name:      pulumi.String(r.ServiceAccount),
+			Namespace: pulumi.String(r.Namespace),
+			Annotations: pulumi.StringMap{
+				RoleArnAnnotation: r.Arn,
+			},
+		},
+	}, opts...)
+}
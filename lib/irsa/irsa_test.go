@@ -0,0 +1,86 @@
+package irsa
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+func TestServiceAccountRole(t *testing.T) {
+	m := mocks.Default()
+	err := m.Run("external-dns.go", func(ctx *pulumi.Context) error {
+		role, err := NewServiceAccountRole(ctx, "external-dns", "external-dns", "external-dns", Policies{
+			Managed: policy.Strings("arn:aws:iam::aws:policy/AmazonRoute53ReadOnlyAccess"),
+			Inline: map[string]policy.Document{
+				"route53": {
+					Statement: []policy.Statement{
+						{
+							Effect:   policy.Allow,
+							Action:   []string{"route53:ChangeResourceRecordSets"},
+							Resource: policy.Strings("arn:aws:route53:::hostedzone/*"),
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = role.NewServiceAccount(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role, ok := m.Resource("aws:iam/role:Role", "external-dns")
+	if !ok {
+		t.Fatal("role was not created")
+	}
+
+	var trust struct {
+		Statement []struct {
+			Principal struct{ Federated string }
+			Condition map[string]map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(role.Inputs["assumeRolePolicy"].StringValue()), &trust); err != nil {
+		t.Fatal(err)
+	}
+	if len(trust.Statement) != 1 {
+		t.Fatalf("expected a single trust statement, got %+v", trust.Statement)
+	}
+	if federated := trust.Statement[0].Principal.Federated; federated != "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF" {
+		t.Errorf("role should trust the cluster's OIDC provider, got %s", federated)
+	}
+	conditions := trust.Statement[0].Condition["StringEquals"]
+	if sub := conditions["oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF:sub"]; sub != "system:serviceaccount:external-dns:external-dns" {
+		t.Errorf("role should be scoped to the service account, got %q", sub)
+	}
+	if aud := conditions["oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF:aud"]; aud != "sts.amazonaws.com" {
+		t.Errorf("role should be scoped to STS tokens, got %q", aud)
+	}
+
+	if _, ok := m.Resource("aws:iam/rolePolicyAttachment:RolePolicyAttachment", "external-dns-0"); !ok {
+		t.Error("managed policy was not attached")
+	}
+	if _, ok := m.Resource("aws:iam/rolePolicy:RolePolicy", "external-dns-route53"); !ok {
+		t.Error("inline policy was not added")
+	}
+
+	serviceAccount, ok := m.Resource("kubernetes:core/v1:ServiceAccount", "external-dns")
+	if !ok {
+		t.Fatal("service account was not created")
+	}
+	metadata := serviceAccount.Inputs["metadata"].ObjectValue()
+	if namespace := metadata["namespace"].StringValue(); namespace != "external-dns" {
+		t.Errorf("service account should be in the external-dns namespace, got %s", namespace)
+	}
+	if _, ok := metadata["annotations"].ObjectValue()[RoleArnAnnotation]; !ok {
+		t.Errorf("service account should be annotated with the role, got %v", metadata["annotations"])
+	}
+}
@@ -0,0 +1,433 @@
+// Package mocks provides a fake Pulumi engine so the programs in this
+// repository can be run in unit tests without any cloud credentials
+package mocks
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+const (
+	// Stack is the name of the stack programs are run against in tests
+	Stack = "test"
+
+	// AccountID is the AWS account programs deploy to in tests
+	AccountID = "123456789012"
+
+	// Region is the AWS region programs deploy to in tests
+	Region = "us-west-2"
+)
+
+// Resource is a resource registered with the mocks. Provider is the
+// reference to the provider the resource was created with, if any
+type Resource struct {
+	Type     string
+	Name     string
+	Inputs   resource.PropertyMap
+	Provider string
+
+	// ID is the ID the resource was imported or read with, and empty
+	// for resources the program creates
+	ID string
+}
+
+// Taggable is the set of resource types used in this repository that accept
+// tags. Resources of other types are still checked if their inputs have tags
+var Taggable = map[string]bool{
+	"aws:autoscaling/group:Group":             true,
+	"aws:cloudwatch/logGroup:LogGroup":        true,
+	"aws:ec2/eip:Eip":                         true,
+	"aws:ec2/flowLog:FlowLog":                 true,
+	"aws:ec2/internetGateway:InternetGateway": true,
+	"aws:ec2/launchTemplate:LaunchTemplate":   true,
+	"aws:ec2/natGateway:NatGateway":           true,
+	"aws:ec2/routeTable:RouteTable":           true,
+	"aws:ec2/securityGroup:SecurityGroup":     true,
+	"aws:ec2/subnet:Subnet":                   true,
+	"aws:ec2/vpc:Vpc":                         true,
+	"aws:ec2/vpcEndpoint:VpcEndpoint":         true,
+	"aws:ecs/cluster:Cluster":                 true,
+	"aws:ecs/service:Service":                 true,
+	"aws:ecs/taskDefinition:TaskDefinition":   true,
+	"aws:eks/addon:Addon":                     true,
+	"aws:eks/cluster:Cluster":                 true,
+	"aws:eks/nodeGroup:NodeGroup":             true,
+	"aws:iam/policy:Policy":                   true,
+	"aws:iam/role:Role":                       true,
+	"aws:kms/key:Key":                         true,
+	"aws:lb/loadBalancer:LoadBalancer":        true,
+	"aws:lb/targetGroup:TargetGroup":          true,
+	"aws:rds/instance:Instance":               true,
+	"aws:rds/subnetGroup:SubnetGroup":         true,
+	"aws:s3/bucket:Bucket":                    true,
+	"aws:ssm/document:Document":               true,
+	"aws:ssm/parameter:Parameter":             true,
+}
+
+// Taggable reports whether the resource accepts tags, either because its
+// type is in Taggable or because tags were set in its inputs
+func (r Resource) Taggable() bool {
+	if Taggable[r.Type] {
+		return true
+	}
+	for _, key := range []resource.PropertyKey{"tags", "tagsCollection"} {
+		if _, ok := r.Inputs[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Tags returns the tags set in the resource's inputs. Autoscaling groups
+// take a list of key/value objects in tags or tagsCollection rather than a
+// map, so both are handled
+func (r Resource) Tags() map[string]string {
+	tags := map[string]string{}
+
+	for _, key := range []resource.PropertyKey{"tags", "tagsCollection"} {
+		value := r.Inputs[key]
+		switch {
+		case value.IsObject():
+			for k, v := range value.ObjectValue() {
+				if v.IsString() {
+					tags[string(k)] = v.StringValue()
+				}
+			}
+		case value.IsArray():
+			for _, tag := range value.ArrayValue() {
+				if !tag.IsObject() {
+					continue
+				}
+				k, v := tag.ObjectValue()["key"], tag.ObjectValue()["value"]
+				if k.IsString() && v.IsString() {
+					tags[k.StringValue()] = v.StringValue()
+				}
+			}
+		}
+	}
+	return tags
+}
+
+// Rule is a security group rule
+type Rule struct {
+	Protocol   string
+	FromPort   int
+	ToPort     int
+	CidrBlocks []string
+
+	// SecurityGroups are the IDs of the source security groups
+	SecurityGroups []string
+}
+
+// Allows reports whether the rule lets traffic from the source, a CIDR or a
+// security group ID, reach the port
+func (r Rule) Allows(source string, port int) bool {
+	if r.Protocol != "-1" && (port < r.FromPort || port > r.ToPort) {
+		return false
+	}
+	for _, s := range append(r.CidrBlocks, r.SecurityGroups...) {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Ingress returns the ingress rules set in a security group's inputs
+func (r Resource) Ingress() []Rule {
+	return rules(r.Inputs["ingress"])
+}
+
+// Egress returns the egress rules set in a security group's inputs
+func (r Resource) Egress() []Rule {
+	return rules(r.Inputs["egress"])
+}
+
+func rules(value resource.PropertyValue) []Rule {
+	if !value.IsArray() {
+		return nil
+	}
+
+	var result []Rule
+	for _, v := range value.ArrayValue() {
+		if !v.IsObject() {
+			continue
+		}
+		obj := v.ObjectValue()
+
+		var rule Rule
+		if p := obj["protocol"]; p.IsString() {
+			rule.Protocol = p.StringValue()
+		}
+		if p := obj["fromPort"]; p.IsNumber() {
+			rule.FromPort = int(p.NumberValue())
+		}
+		if p := obj["toPort"]; p.IsNumber() {
+			rule.ToPort = int(p.NumberValue())
+		}
+		if cidrs := obj["cidrBlocks"]; cidrs.IsArray() {
+			for _, c := range cidrs.ArrayValue() {
+				if c.IsString() {
+					rule.CidrBlocks = append(rule.CidrBlocks, c.StringValue())
+				}
+			}
+		}
+		if groups := obj["securityGroups"]; groups.IsArray() {
+			for _, g := range groups.ArrayValue() {
+				if g.IsString() {
+					rule.SecurityGroups = append(rule.SecurityGroups, g.StringValue())
+				}
+			}
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
+// Mocks records every resource a program registers, and answers stack
+// references and provider function calls with canned values
+type Mocks struct {
+	mu        sync.Mutex
+	resources []Resource
+	stacks    map[string]map[string]interface{}
+	calls     map[string]map[string]interface{}
+	callErrs  map[string]error
+	outputs   map[string]map[string]interface{}
+	config    map[string]string
+}
+
+// New creates mocks without any stacks, which deploy to AccountID in Region
+func New() *Mocks {
+	return &Mocks{
+		stacks: map[string]map[string]interface{}{},
+		calls: map[string]map[string]interface{}{
+			"aws:index/getCallerIdentity:getCallerIdentity": {"accountId": AccountID},
+		},
+		callErrs: map[string]error{},
+		outputs:  map[string]map[string]interface{}{},
+		config:   map[string]string{"aws:region": Region},
+	}
+}
+
+// Default creates mocks with fake outputs for every stack in the repository
+func Default() *Mocks {
+	return New().
+		SetStackOutputs("jaxxstorm/vpc.go/"+Stack, map[string]interface{}{
+			"vpc": map[string]interface{}{
+				"schemaVersion":  2,
+				"id":             "vpc-0123456789abcdef0",
+				"arn":            "arn:aws:ec2:us-west-2:123456789012:vpc/vpc-0123456789abcdef0",
+				"cidr":           "172.1.0.0/16",
+				"publicSubnets":  []interface{}{"subnet-public-a", "subnet-public-b", "subnet-public-c"},
+				"privateSubnets": []interface{}{"subnet-private-a", "subnet-private-b", "subnet-private-c"},
+				"publicSubnetsByZone": map[string]interface{}{
+					"us-west-2a": "subnet-public-a",
+					"us-west-2b": "subnet-public-b",
+					"us-west-2c": "subnet-public-c",
+				},
+				"privateSubnetsByZone": map[string]interface{}{
+					"us-west-2a": "subnet-private-a",
+					"us-west-2b": "subnet-private-b",
+					"us-west-2c": "subnet-private-c",
+				},
+				"routeTableIds": []interface{}{"rtb-public", "rtb-private-a", "rtb-private-b", "rtb-private-c"},
+				"natGatewayIps": []interface{}{"198.51.100.1", "198.51.100.2", "198.51.100.3"},
+				"endpoints": map[string]interface{}{
+					"s3":       "vpce-s3",
+					"dynamodb": "vpce-dynamodb",
+				},
+			},
+		}).
+		SetStackOutputs("jaxxstorm/alb.go/"+Stack, map[string]interface{}{
+			"arn":              "arn:aws:elasticloadbalancing:us-west-2:123456789012:loadbalancer/app/web/0123456789abcdef",
+			"dnsName":          "web-123456789.us-west-2.elb.amazonaws.com",
+			"httpListenerArn":  "arn:aws:elasticloadbalancing:us-west-2:123456789012:listener/app/web/0123456789abcdef/http",
+			"httpsListenerArn": "arn:aws:elasticloadbalancing:us-west-2:123456789012:listener/app/web/0123456789abcdef/https",
+			"certificateArn":   "arn:aws:acm:us-west-2:123456789012:certificate/0123abcd-0123-0123-0123-0123456789ab",
+		}).
+		SetStackOutputs("jaxxstorm/db.go/"+Stack, map[string]interface{}{
+			"arn":      "arn:aws:rds:us-west-2:123456789012:db:db",
+			"endpoint": "db.abcdefghijkl.us-west-2.rds.amazonaws.com:3306",
+			"username": "admin",
+			"password": "password",
+		}).
+		SetStackOutputs("jaxxstorm/ecs.go/"+Stack, map[string]interface{}{
+			"clusterID":       "cluster",
+			"clusterArn":      "arn:aws:ecs:us-west-2:123456789012:cluster/cluster",
+			"taskExecRoleArn": "arn:aws:iam::123456789012:role/task-exec-role",
+		}).
+		SetStackOutputs("jaxxstorm/eks.go/"+Stack, map[string]interface{}{
+			"clusterName":       "lbriggs",
+			"kubernetesVersion": "1.18",
+			"kubeconfig":        "{}",
+			"oidcProviderArn":   "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF",
+			"oidcIssuerUrl":     "https://oidc.eks.us-west-2.amazonaws.com/id/0123456789ABCDEF",
+		}).
+		SetStackOutputs("jaxxstorm/bastion.go/"+Stack, map[string]interface{}{
+			"autoScalingGroupName":  "bastion-1",
+			"autoScalingGroupNames": []interface{}{"bastion-1"},
+			"securityGroupId":       "sg-bastion",
+		})
+}
+
+// SetStackOutputs sets the outputs returned when the program references the
+// named stack, e.g. "jaxxstorm/vpc.go/test"
+func (m *Mocks) SetStackOutputs(name string, outputs map[string]interface{}) *Mocks {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stacks[name] = outputs
+	return m
+}
+
+// SetConfig sets a stack config value every run starts with, before any set
+// with Config. Keys are fully qualified, e.g. "aws:region"
+func (m *Mocks) SetConfig(key, value string) *Mocks {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.config[key] = value
+	return m
+}
+
+// SetCallResult sets the result returned when the program calls the provider
+// function with the given token, e.g. "aws:index/getAmi:getAmi", replacing
+// any error set with SetCallError
+func (m *Mocks) SetCallResult(token string, result map[string]interface{}) *Mocks {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls[token] = result
+	delete(m.callErrs, token)
+	return m
+}
+
+// SetCallError makes calls to the provider function with the given token
+// fail, e.g. for a lookup of something that doesn't exist
+func (m *Mocks) SetCallError(token string, err error) *Mocks {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.callErrs[token] = err
+	return m
+}
+
+// SetResourceOutputs sets outputs that are added to the state of every
+// resource of the given type, for outputs the provider would compute
+func (m *Mocks) SetResourceOutputs(typeToken string, outputs map[string]interface{}) *Mocks {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.outputs[typeToken] = outputs
+	return m
+}
+
+// Call implements pulumi.MockResourceMonitor
+func (m *Mocks) Call(token string, args resource.PropertyMap, provider string) (resource.PropertyMap, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err, ok := m.callErrs[token]; ok {
+		return nil, err
+	}
+	return resource.NewPropertyMapFromMap(m.calls[token]), nil
+}
+
+// NewResource implements pulumi.MockResourceMonitor
+func (m *Mocks) NewResource(typeToken, name string, inputs resource.PropertyMap,
+	provider, id string) (string, resource.PropertyMap, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resources = append(m.resources, Resource{Type: typeToken, Name: name, Inputs: inputs, Provider: provider, ID: id})
+
+	if typeToken == "pulumi:pulumi:StackReference" {
+		outputs, ok := m.stacks[name]
+		if !ok {
+			return "", nil, fmt.Errorf("no outputs mocked for stack %s", name)
+		}
+		return name, resource.NewPropertyMapFromMap(map[string]interface{}{
+			"name":    name,
+			"outputs": outputs,
+		}), nil
+	}
+
+	if id == "" {
+		id = name + "_id"
+	}
+
+	state := inputs.Copy()
+	for k, v := range resource.NewPropertyMapFromMap(m.outputs[typeToken]) {
+		state[k] = v
+	}
+	return id, state, nil
+}
+
+// Resources returns every registered resource of the given type, or every
+// registered resource if the type is empty
+func (m *Mocks) Resources(typeToken string) []Resource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var resources []Resource
+	for _, r := range m.resources {
+		if typeToken == "" || r.Type == typeToken {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// MissingTag returns every registered taggable resource without the given tag
+func (m *Mocks) MissingTag(key string) []Resource {
+	var resources []Resource
+	for _, r := range m.Resources("") {
+		if !r.Taggable() {
+			continue
+		}
+		if _, ok := r.Tags()[key]; !ok {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// Resource returns the registered resource with the given type and name
+func (m *Mocks) Resource(typeToken, name string) (Resource, bool) {
+	for _, r := range m.Resources(typeToken) {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Resource{}, false
+}
+
+// Run runs a program against the mocks as the given project
+func (m *Mocks) Run(project string, body pulumi.RunFunc, opts ...pulumi.RunOption) error {
+	m.mu.Lock()
+	config := map[string]string{}
+	for k, v := range m.config {
+		config[k] = v
+	}
+	m.mu.Unlock()
+
+	opts = append([]pulumi.RunOption{pulumi.WithMocks(project, Stack, m), Config(config)}, opts...)
+	return pulumi.RunErr(body, opts...)
+}
+
+// Config sets stack config values for a run, on top of those set with
+// SetConfig. Keys are fully qualified, e.g. "bastion.go:tailScaleHostKey"
+func Config(config map[string]string) pulumi.RunOption {
+	return func(info *pulumi.RunInfo) {
+		if info.Config == nil {
+			info.Config = map[string]string{}
+		}
+		for k, v := range config {
+			info.Config[k] = v
+		}
+	}
+}
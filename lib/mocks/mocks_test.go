@@ -0,0 +1,76 @@
+package mocks
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/common/resource"
+)
+
+func TestRuleAllows(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		cidr string
+		port int
+		want bool
+	}{
+		{"in range", Rule{Protocol: "tcp", FromPort: 80, ToPort: 443, CidrBlocks: []string{"0.0.0.0/0"}}, "0.0.0.0/0", 443, true},
+		{"out of range", Rule{Protocol: "tcp", FromPort: 80, ToPort: 80, CidrBlocks: []string{"0.0.0.0/0"}}, "0.0.0.0/0", 443, false},
+		{"other cidr", Rule{Protocol: "tcp", FromPort: 22, ToPort: 22, CidrBlocks: []string{"10.0.0.0/8"}}, "0.0.0.0/0", 22, false},
+		{"all traffic", Rule{Protocol: "-1", CidrBlocks: []string{"0.0.0.0/0"}}, "0.0.0.0/0", 3306, true},
+		{"security group", Rule{Protocol: "tcp", FromPort: 443, ToPort: 443, SecurityGroups: []string{"sg-123"}}, "sg-123", 443, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Allows(tt.cidr, tt.port); got != tt.want {
+				t.Errorf("Allows(%q, %d) = %v, want %v", tt.cidr, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	m := New()
+	_, _, _ = m.NewResource("aws:iam/role:Role", "tagged", resource.NewPropertyMapFromMap(map[string]interface{}{
+		"tags": map[string]interface{}{"Owner": "lbriggs"},
+	}), "", "")
+	_, _, _ = m.NewResource("aws:autoscaling/group:Group", "asg", resource.NewPropertyMapFromMap(map[string]interface{}{
+		"tags": []interface{}{
+			map[string]interface{}{"key": "Owner", "value": "lbriggs", "propagateAtLaunch": true},
+		},
+	}), "", "")
+	_, _, _ = m.NewResource("aws:ec2/securityGroup:SecurityGroup", "untagged", resource.PropertyMap{}, "", "")
+	_, _, _ = m.NewResource("aws:iam/rolePolicyAttachment:RolePolicyAttachment", "untaggable", resource.PropertyMap{}, "", "")
+
+	missing := m.MissingTag("Owner")
+	if len(missing) != 1 || missing[0].Name != "untagged" {
+		t.Errorf("MissingTag() = %v, want only the untagged security group", missing)
+	}
+}
+
+func TestTaggable(t *testing.T) {
+	for _, typeToken := range []string{
+		"aws:s3/bucket:Bucket",
+		"aws:cloudwatch/logGroup:LogGroup",
+		"aws:kms/key:Key",
+		"aws:ssm/document:Document",
+		"aws:ec2/vpcEndpoint:VpcEndpoint",
+		"aws:ec2/flowLog:FlowLog",
+		"aws:iam/policy:Policy",
+	} {
+		if !(Resource{Type: typeToken}).Taggable() {
+			t.Errorf("%s should be taggable", typeToken)
+		}
+	}
+
+	unlisted := Resource{Type: "aws:iam/openIdConnectProvider:OpenIdConnectProvider", Inputs: resource.NewPropertyMapFromMap(map[string]interface{}{
+		"tags": map[string]interface{}{},
+	})}
+	if !unlisted.Taggable() {
+		t.Error("a resource with tags in its inputs should be taggable")
+	}
+	if (Resource{Type: "aws:iam/rolePolicyAttachment:RolePolicyAttachment"}).Taggable() {
+		t.Error("a role policy attachment can't be tagged")
+	}
+}
@@ -0,0 +1,245 @@
+// Package policy builds IAM policy documents from typed statements. Any value
+// in a document can be a pulumi.StringInput, so ARNs of resources that don't
+// exist yet can be used directly
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// Version is the IAM policy language version used for every document
+const Version = "2012-10-17"
+
+const (
+	// Allow is the effect of a statement which grants access
+	Allow = "Allow"
+
+	// Deny is the effect of a statement which denies access
+	Deny = "Deny"
+)
+
+// Document is an IAM policy document
+type Document struct {
+	Statement []Statement
+}
+
+// Statement is a single statement in a policy document
+type Statement struct {
+	Sid       string
+	Effect    string
+	Principal *Principal
+	Action    []string
+	Resource  []pulumi.StringInput
+	Condition []Condition
+}
+
+// Principal is the entity a trust policy statement applies to
+type Principal struct {
+	AWS       []pulumi.StringInput
+	Service   []pulumi.StringInput
+	Federated []pulumi.StringInput
+}
+
+// Condition restricts when a statement applies, e.g. a StringEquals test
+// that the variable "oidc.eks.../id/...:sub" has one of the given values
+type Condition struct {
+	Test     string
+	Variable pulumi.StringInput
+	Values   []pulumi.StringInput
+}
+
+// Strings converts strings to a list of inputs
+func Strings(values ...string) []pulumi.StringInput {
+	inputs := make([]pulumi.StringInput, len(values))
+	for i, v := range values {
+		inputs[i] = pulumi.String(v)
+	}
+	return inputs
+}
+
+// ServiceAssumeRole returns a trust policy which lets the given AWS
+// services, e.g. "ecs-tasks.amazonaws.com", assume a role
+func ServiceAssumeRole(services ...string) Document {
+	return Document{
+		Statement: []Statement{
+			{
+				Effect:    Allow,
+				Principal: &Principal{Service: Strings(services...)},
+				Action:    []string{"sts:AssumeRole"},
+			},
+		},
+	}
+}
+
+// WebIdentityAssumeRole returns a trust policy which lets tokens issued by the
+// OIDC provider with the given ARN for STS assume a role, when the token's
+// subject matches sub, e.g. "system:serviceaccount:<namespace>:<service account>"
+func WebIdentityAssumeRole(oidcProviderArn pulumi.StringInput, sub string) Document {
+	return Document{
+		Statement: []Statement{
+			{
+				Effect:    Allow,
+				Principal: &Principal{Federated: []pulumi.StringInput{oidcProviderArn}},
+				Action:    []string{"sts:AssumeRoleWithWebIdentity"},
+				Condition: []Condition{
+					{
+						Test:     "StringEquals",
+						Variable: ProviderVariable(oidcProviderArn, "sub"),
+						Values:   Strings(sub),
+					},
+					{
+						Test:     "StringEquals",
+						Variable: ProviderVariable(oidcProviderArn, "aud"),
+						Values:   Strings("sts.amazonaws.com"),
+					},
+				},
+			},
+		},
+	}
+}
+
+// ProviderVariable returns the name of a condition variable for tokens issued
+// by the OIDC provider with the given ARN, e.g. "oidc.eks.../id/...:sub"
+func ProviderVariable(oidcProviderArn pulumi.StringInput, claim string) pulumi.StringOutput {
+	return oidcProviderArn.ToStringOutput().ApplyT(func(arn string) (string, error) {
+		parts := strings.SplitN(arn, ":oidc-provider/", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("%q is not an OIDC provider ARN", arn)
+		}
+		return fmt.Sprintf("%s:%s", parts[1], claim), nil
+	}).(pulumi.StringOutput)
+}
+
+// Validate checks the document is well formed
+func (d Document) Validate() error {
+	if len(d.Statement) == 0 {
+		return errors.New("policy document has no statements")
+	}
+
+	for i, s := range d.Statement {
+		if s.Effect != Allow && s.Effect != Deny {
+			return fmt.Errorf("statement %d has unknown effect %q", i, s.Effect)
+		}
+		if len(s.Action) == 0 {
+			return fmt.Errorf("statement %d has no actions", i)
+		}
+		for _, action := range s.Action {
+			if action == "" {
+				return fmt.Errorf("statement %d has an empty action", i)
+			}
+		}
+		if s.Principal == nil && len(s.Resource) == 0 {
+			return fmt.Errorf("statement %d has neither a principal nor a resource", i)
+		}
+		if s.Principal != nil && len(s.Principal.AWS)+len(s.Principal.Service)+len(s.Principal.Federated) == 0 {
+			return fmt.Errorf("statement %d has an empty principal", i)
+		}
+		for _, c := range s.Condition {
+			if c.Test == "" || c.Variable == nil || len(c.Values) == 0 {
+				return fmt.Errorf("statement %d has an incomplete condition", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Render validates the document and renders it as JSON once every
+// input in it is known
+func (d Document) Render() (pulumi.StringOutput, error) {
+	if err := d.Validate(); err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	// collect every input in the document, then render it with the resolved
+	// values, which are consumed in the same order
+	var inputs []interface{}
+	d.walk(func(input pulumi.StringInput) interface{} {
+		inputs = append(inputs, input)
+		return nil
+	})
+
+	return pulumi.All(inputs...).ApplyT(func(values []interface{}) (string, error) {
+		next := 0
+		doc := d.walk(func(pulumi.StringInput) interface{} {
+			value := values[next]
+			next++
+			return value
+		})
+
+		rendered, err := json.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+		return string(rendered), nil
+	}).(pulumi.StringOutput), nil
+}
+
+// walk builds the JSON structure of the document, calling resolve for each
+// input in a fixed order
+func (d Document) walk(resolve func(pulumi.StringInput) interface{}) map[string]interface{} {
+	values := func(inputs []pulumi.StringInput) interface{} {
+		resolved := make([]interface{}, len(inputs))
+		for i, input := range inputs {
+			resolved[i] = resolve(input)
+		}
+		if len(resolved) == 1 {
+			return resolved[0]
+		}
+		return resolved
+	}
+
+	statements := make([]interface{}, len(d.Statement))
+	for i, s := range d.Statement {
+		statement := map[string]interface{}{
+			"Effect": s.Effect,
+		}
+		if s.Sid != "" {
+			statement["Sid"] = s.Sid
+		}
+		if len(s.Action) == 1 {
+			statement["Action"] = s.Action[0]
+		} else {
+			statement["Action"] = s.Action
+		}
+		if len(s.Resource) > 0 {
+			statement["Resource"] = values(s.Resource)
+		}
+		if s.Principal != nil {
+			principal := map[string]interface{}{}
+			if len(s.Principal.AWS) > 0 {
+				principal["AWS"] = values(s.Principal.AWS)
+			}
+			if len(s.Principal.Service) > 0 {
+				principal["Service"] = values(s.Principal.Service)
+			}
+			if len(s.Principal.Federated) > 0 {
+				principal["Federated"] = values(s.Principal.Federated)
+			}
+			statement["Principal"] = principal
+		}
+		if len(s.Condition) > 0 {
+			conditions := map[string]map[string]interface{}{}
+			for _, c := range s.Condition {
+				variable := resolve(c.Variable)
+				if conditions[c.Test] == nil {
+					conditions[c.Test] = map[string]interface{}{}
+				}
+				key, _ := variable.(string)
+				conditions[c.Test][key] = values(c.Values)
+			}
+			statement["Condition"] = conditions
+		}
+		statements[i] = statement
+	}
+
+	return map[string]interface{}{
+		"Version":   Version,
+		"Statement": statements,
+	}
+}
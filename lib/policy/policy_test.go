@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+func render(t *testing.T, d Document) map[string]interface{} {
+	t.Helper()
+
+	output, err := d.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := make(chan string)
+	output.ApplyT(func(s string) string {
+		rendered <- s
+		return s
+	})
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(<-rendered), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestServiceAssumeRole(t *testing.T) {
+	doc := render(t, ServiceAssumeRole("ec2.amazonaws.com", "ssm.amazonaws.com"))
+
+	want := map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []interface{}{
+			map[string]interface{}{
+				"Effect": "Allow",
+				"Action": "sts:AssumeRole",
+				"Principal": map[string]interface{}{
+					"Service": []interface{}{"ec2.amazonaws.com", "ssm.amazonaws.com"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("got %v, want %v", doc, want)
+	}
+}
+
+func TestWebIdentityAssumeRole(t *testing.T) {
+	arn := pulumi.String("arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-west-2.amazonaws.com/id/ABCDEF").ToStringOutput()
+	doc := render(t, WebIdentityAssumeRole(arn, "system:serviceaccount:external-dns:external-dns"))
+
+	statement := doc["Statement"].([]interface{})[0].(map[string]interface{})
+	if statement["Action"] != "sts:AssumeRoleWithWebIdentity" {
+		t.Errorf("unexpected action %v", statement["Action"])
+	}
+	if federated := statement["Principal"].(map[string]interface{})["Federated"]; federated != "arn:aws:iam::123456789012:oidc-provider/oidc.eks.us-west-2.amazonaws.com/id/ABCDEF" {
+		t.Errorf("unexpected principal %v", federated)
+	}
+
+	want := map[string]interface{}{
+		"StringEquals": map[string]interface{}{
+			"oidc.eks.us-west-2.amazonaws.com/id/ABCDEF:sub": "system:serviceaccount:external-dns:external-dns",
+			"oidc.eks.us-west-2.amazonaws.com/id/ABCDEF:aud": "sts.amazonaws.com",
+		},
+	}
+	if !reflect.DeepEqual(statement["Condition"], want) {
+		t.Errorf("got condition %v, want %v", statement["Condition"], want)
+	}
+}
+
+func TestResourceOutputs(t *testing.T) {
+	doc := render(t, Document{
+		Statement: []Statement{
+			{
+				Effect:   Allow,
+				Action:   []string{"ssm:GetParameters"},
+				Resource: []pulumi.StringInput{pulumi.String("arn:aws:ssm:us-west-2:123456789012:parameter/key").ToStringOutput()},
+			},
+			{
+				Effect:   Allow,
+				Action:   []string{"ssm:DescribeParameters", "ssm:ListTagsForResource"},
+				Resource: Strings("*"),
+			},
+		},
+	})
+
+	statements := doc["Statement"].([]interface{})
+	if resource := statements[0].(map[string]interface{})["Resource"]; resource != "arn:aws:ssm:us-west-2:123456789012:parameter/key" {
+		t.Errorf("unexpected resource %v", resource)
+	}
+	if actions := statements[1].(map[string]interface{})["Action"]; len(actions.([]interface{})) != 2 {
+		t.Errorf("unexpected actions %v", actions)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  Document
+		err  string
+	}{
+		{"no statements", Document{}, "no statements"},
+		{"unknown effect", Document{Statement: []Statement{{Effect: "Maybe", Action: []string{"s3:GetObject"}, Resource: Strings("*")}}}, "unknown effect"},
+		{"no actions", Document{Statement: []Statement{{Effect: Allow, Resource: Strings("*")}}}, "no actions"},
+		{"empty action", Document{Statement: []Statement{{Effect: Allow, Action: []string{""}, Resource: Strings("*")}}}, "empty action"},
+		{"no resource", Document{Statement: []Statement{{Effect: Allow, Action: []string{"s3:GetObject"}}}}, "neither a principal nor a resource"},
+		{"empty principal", Document{Statement: []Statement{{Effect: Allow, Action: []string{"sts:AssumeRole"}, Principal: &Principal{}}}}, "empty principal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.doc.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+
+	if err := ServiceAssumeRole("eks.amazonaws.com").Validate(); err != nil {
+		t.Errorf("Validate() = %v for a valid document", err)
+	}
+}
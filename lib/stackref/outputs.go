@@ -0,0 +1,159 @@
+package stackref
+
+import (
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// VpcSchemaVersion is the version of the vpc output of vpc.go read by Vpc
+const VpcSchemaVersion = 2
+
+// VpcOutputs are the outputs exported by vpc.go
+type VpcOutputs struct {
+	ID                   pulumi.StringOutput
+	Arn                  pulumi.StringOutput
+	Cidr                 pulumi.StringOutput
+	PublicSubnets        pulumi.StringArrayOutput
+	PrivateSubnets       pulumi.StringArrayOutput
+	PublicSubnetsByZone  pulumi.StringMapOutput
+	PrivateSubnetsByZone pulumi.StringMapOutput
+	RouteTableIDs        pulumi.StringArrayOutput
+	NatGatewayIPs        pulumi.StringArrayOutput
+	Endpoints            pulumi.StringMapOutput
+}
+
+// Vpc references the vpc.go stack
+func Vpc(ctx *pulumi.Context) (*VpcOutputs, error) {
+	ref, err := New(ctx, "vpc")
+	if err != nil {
+		return nil, err
+	}
+	ref.RequireSchema("vpc", VpcSchemaVersion)
+
+	return &VpcOutputs{
+		ID:                   ref.RequireString("vpc.id"),
+		Arn:                  ref.RequireString("vpc.arn"),
+		Cidr:                 ref.RequireString("vpc.cidr"),
+		PublicSubnets:        ref.RequireStringArray("vpc.publicSubnets"),
+		PrivateSubnets:       ref.RequireStringArray("vpc.privateSubnets"),
+		PublicSubnetsByZone:  ref.RequireStringMap("vpc.publicSubnetsByZone"),
+		PrivateSubnetsByZone: ref.RequireStringMap("vpc.privateSubnetsByZone"),
+		RouteTableIDs:        ref.RequireStringArray("vpc.routeTableIds"),
+		NatGatewayIPs:        ref.RequireStringArray("vpc.natGatewayIps"),
+		Endpoints:            ref.RequireStringMap("vpc.endpoints"),
+	}, nil
+}
+
+// AlbOutputs are the outputs exported by alb.go
+type AlbOutputs struct {
+	Arn              pulumi.StringOutput
+	DnsName          pulumi.StringOutput
+	HttpListenerArn  pulumi.StringOutput
+	HttpsListenerArn pulumi.StringOutput
+	CertificateArn   pulumi.StringOutput
+}
+
+// Alb references the alb.go stack
+func Alb(ctx *pulumi.Context) (*AlbOutputs, error) {
+	ref, err := New(ctx, "alb")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AlbOutputs{
+		Arn:              ref.RequireString("arn"),
+		DnsName:          ref.RequireString("dnsName"),
+		HttpListenerArn:  ref.RequireString("httpListenerArn"),
+		HttpsListenerArn: ref.RequireString("httpsListenerArn"),
+		CertificateArn:   ref.RequireString("certificateArn"),
+	}, nil
+}
+
+// DbOutputs are the outputs exported by db.go
+type DbOutputs struct {
+	Arn      pulumi.StringOutput
+	Endpoint pulumi.StringOutput
+	Username pulumi.StringOutput
+	Password pulumi.StringOutput
+}
+
+// Db references the db.go stack
+func Db(ctx *pulumi.Context) (*DbOutputs, error) {
+	ref, err := New(ctx, "db")
+	if err != nil {
+		return nil, err
+	}
+
+	return &DbOutputs{
+		Arn:      ref.RequireString("arn"),
+		Endpoint: ref.RequireString("endpoint"),
+		Username: ref.RequireString("username"),
+		Password: ref.RequireString("password"),
+	}, nil
+}
+
+// EcsOutputs are the outputs exported by ecs.go
+type EcsOutputs struct {
+	ClusterID       pulumi.StringOutput
+	ClusterArn      pulumi.StringOutput
+	TaskExecRoleArn pulumi.StringOutput
+}
+
+// Ecs references the ecs.go stack
+func Ecs(ctx *pulumi.Context) (*EcsOutputs, error) {
+	ref, err := New(ctx, "ecs")
+	if err != nil {
+		return nil, err
+	}
+
+	return &EcsOutputs{
+		ClusterID:       ref.RequireString("clusterID"),
+		ClusterArn:      ref.RequireString("clusterArn"),
+		TaskExecRoleArn: ref.RequireString("taskExecRoleArn"),
+	}, nil
+}
+
+// EksOutputs are the outputs exported by eks.go
+type EksOutputs struct {
+	ClusterName       pulumi.StringOutput
+	KubernetesVersion pulumi.StringOutput
+	Kubeconfig        pulumi.StringOutput
+	OidcProviderArn   pulumi.StringOutput
+	OidcIssuerURL     pulumi.StringOutput
+}
+
+// Eks references the eks.go stack
+func Eks(ctx *pulumi.Context) (*EksOutputs, error) {
+	ref, err := New(ctx, "eks")
+	if err != nil {
+		return nil, err
+	}
+
+	return &EksOutputs{
+		ClusterName:       ref.RequireString("clusterName"),
+		KubernetesVersion: ref.RequireString("kubernetesVersion"),
+		Kubeconfig:        ref.RequireString("kubeconfig"),
+		OidcProviderArn:   ref.RequireString("oidcProviderArn"),
+		OidcIssuerURL:     ref.RequireString("oidcIssuerUrl"),
+	}, nil
+}
+
+// BastionOutputs are the outputs exported by bastion.go
+type BastionOutputs struct {
+	AutoScalingGroupName  pulumi.StringOutput
+	AutoScalingGroupNames pulumi.StringArrayOutput
+	SecurityGroupID       pulumi.StringOutput
+}
+
+// Bastion references the bastion.go stack
+func Bastion(ctx *pulumi.Context) (*BastionOutputs, error) {
+	ref, err := New(ctx, "bastion")
+	if err != nil {
+		return nil, err
+	}
+
+	return &BastionOutputs{
+		AutoScalingGroupName:  ref.RequireString("autoScalingGroupName"),
+		AutoScalingGroupNames: ref.RequireStringArray("autoScalingGroupNames"),
+		SecurityGroupID:       ref.RequireString("securityGroupId"),
+	}, nil
+}
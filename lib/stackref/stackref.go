@@ -0,0 +1,201 @@
+// Package stackref provides typed access to the outputs exported by the stacks
+// in this repository, so programs don't need to build stack slugs or cast
+// outputs by hand
+package stackref
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi/config"
+)
+
+const (
+	// ConfigNamespace is the config namespace shared by every stack in the repo
+	ConfigNamespace = "iac"
+
+	// DefaultOrg is the Pulumi organization used when iac:org isn't set
+	DefaultOrg = "jaxxstorm"
+)
+
+// Reference is a stack reference whose accessors fail the deployment
+// when a required output is missing, rather than resolving to nil
+type Reference struct {
+	*pulumi.StackReference
+
+	// StackName is the fully qualified name of the referenced stack
+	StackName string
+
+	schemas map[string]int
+}
+
+var (
+	mu   sync.Mutex
+	refs = map[*pulumi.Context]map[string]*Reference{}
+)
+
+// Name returns the fully qualified name of an upstream stack in the form
+// org/project/stack.
+//
+// A program can point at a specific stack with <program>:<dependency>Stack,
+// e.g. alb.go:vpcStack. Otherwise the org is read from iac:org and the
+// project from iac:<dependency>Project, defaulting to "<dependency>.go", and
+// the stack name matches the current stack
+func Name(ctx *pulumi.Context, dependency string) string {
+	if name := config.New(ctx, "").Get(dependency + "Stack"); name != "" {
+		return name
+	}
+
+	project := config.New(ctx, ConfigNamespace).Get(dependency + "Project")
+	if project == "" {
+		project = fmt.Sprintf("%s.go", dependency)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", org(ctx), project, ctx.Stack())
+}
+
+// org returns iac:org, or DefaultOrg when it isn't set
+func org(ctx *pulumi.Context) string {
+	if org := config.New(ctx, ConfigNamespace).Get("org"); org != "" {
+		return org
+	}
+	return DefaultOrg
+}
+
+// New creates a reference to the upstream stack for the given dependency, e.g.
+// "vpc". A stack can only be referenced once in a program, so later calls for
+// the same stack return the first reference and ignore opts
+func New(ctx *pulumi.Context, dependency string, opts ...pulumi.ResourceOption) (*Reference, error) {
+	return reference(ctx, Name(ctx, dependency), dependency, opts...)
+}
+
+// Self creates a reference to the current stack, to read the outputs of its
+// last update. A stack which has never been updated has no outputs
+func Self(ctx *pulumi.Context, opts ...pulumi.ResourceOption) (*Reference, error) {
+	return reference(ctx, fmt.Sprintf("%s/%s/%s", org(ctx), ctx.Project(), ctx.Stack()), "own", opts...)
+}
+
+func reference(ctx *pulumi.Context, name, dependency string, opts ...pulumi.ResourceOption) (*Reference, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if ref, ok := refs[ctx][name]; ok {
+		return ref, nil
+	}
+
+	stackRef, err := pulumi.NewStackReference(ctx, name, nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s stack reference: %w", dependency, err)
+	}
+
+	ref := &Reference{StackReference: stackRef, StackName: name, schemas: map[string]int{}}
+	if refs[ctx] == nil {
+		refs[ctx] = map[string]*Reference{}
+	}
+	refs[ctx][name] = ref
+	return ref, nil
+}
+
+// RequireSchema declares that the named structured output must have the given
+// schemaVersion. Lookups of its fields fail if the version doesn't match
+func (r *Reference) RequireSchema(key string, version int) {
+	r.schemas[key] = version
+}
+
+// RequireOutput returns the named output, failing if the stack doesn't export
+// it. Fields of structured outputs are separated by dots, e.g. "vpc.cidr"
+func (r *Reference) RequireOutput(key string) pulumi.AnyOutput {
+	return r.Outputs.ApplyT(func(outputs map[string]interface{}) (interface{}, error) {
+		path := strings.Split(key, ".")
+
+		value, ok := outputs[path[0]]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("stack %s does not export required output %q", r.StackName, path[0])
+		}
+
+		if version, ok := r.schemas[path[0]]; ok {
+			object, _ := value.(map[string]interface{})
+			if got, _ := object["schemaVersion"].(float64); int(got) != version {
+				return nil, fmt.Errorf("output %q of stack %s has schema version %v, expected %d",
+					path[0], r.StackName, object["schemaVersion"], version)
+			}
+		}
+
+		for i := 1; i < len(path); i++ {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("output %q of stack %s is a %T, not an object",
+					strings.Join(path[:i], "."), r.StackName, value)
+			}
+
+			value, ok = object[path[i]]
+			if !ok || value == nil {
+				return nil, fmt.Errorf("stack %s does not export required output %q", r.StackName, key)
+			}
+		}
+
+		return value, nil
+	}).(pulumi.AnyOutput)
+}
+
+// GetBool returns the named top level output as a bool, or false if the
+// stack doesn't export it
+func (r *Reference) GetBool(key string) pulumi.BoolOutput {
+	return r.Outputs.ApplyT(func(outputs map[string]interface{}) bool {
+		value, _ := outputs[key].(bool)
+		return value
+	}).(pulumi.BoolOutput)
+}
+
+// RequireString returns the named output as a string
+func (r *Reference) RequireString(key string) pulumi.StringOutput {
+	return r.RequireOutput(key).ApplyT(func(value interface{}) (string, error) {
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("output %q of stack %s is a %T, not a string", key, r.StackName, value)
+		}
+		return s, nil
+	}).(pulumi.StringOutput)
+}
+
+// RequireStringArray returns the named output as a list of strings
+func (r *Reference) RequireStringArray(key string) pulumi.StringArrayOutput {
+	return r.RequireOutput(key).ApplyT(func(value interface{}) ([]string, error) {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("output %q of stack %s is a %T, not a list", key, r.StackName, value)
+		}
+
+		result := make([]string, len(items))
+		for i, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("output %q of stack %s has a %T at index %d, not a string", key, r.StackName, item, i)
+			}
+			result[i] = s
+		}
+		return result, nil
+	}).(pulumi.StringArrayOutput)
+}
+
+// RequireStringMap returns the named output as a map of strings
+func (r *Reference) RequireStringMap(key string) pulumi.StringMapOutput {
+	return r.RequireOutput(key).ApplyT(func(value interface{}) (map[string]string, error) {
+		items, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("output %q of stack %s is a %T, not an object", key, r.StackName, value)
+		}
+
+		result := make(map[string]string, len(items))
+		for k, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("output %q of stack %s has a %T at key %q, not a string", key, r.StackName, item, k)
+			}
+			result[k] = s
+		}
+		return result, nil
+	}).(pulumi.StringMapOutput)
+}
@@ -0,0 +1,197 @@
+package stackref
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   string
+	}{
+		{
+			name: "defaults",
+			want: "jaxxstorm/vpc.go/test",
+		},
+		{
+			name:   "org",
+			config: map[string]string{"iac:org": "acme"},
+			want:   "acme/vpc.go/test",
+		},
+		{
+			name:   "project",
+			config: map[string]string{"iac:org": "acme", "iac:vpcProject": "network"},
+			want:   "acme/network/test",
+		},
+		{
+			name:   "project without org",
+			config: map[string]string{"iac:vpcProject": "network"},
+			want:   "jaxxstorm/network/test",
+		},
+		{
+			name:   "stack override",
+			config: map[string]string{"alb.go:vpcStack": "other/vpc.go/staging"},
+			want:   "other/vpc.go/staging",
+		},
+		{
+			name:   "stack override wins",
+			config: map[string]string{"iac:org": "acme", "iac:vpcProject": "network", "alb.go:vpcStack": "other/vpc.go/staging"},
+			want:   "other/vpc.go/staging",
+		},
+		{
+			name:   "stack override for another program",
+			config: map[string]string{"db.go:vpcStack": "other/vpc.go/staging"},
+			want:   "jaxxstorm/vpc.go/test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			err := mocks.New().Run("alb.go", func(ctx *pulumi.Context) error {
+				got = Name(ctx, "vpc")
+				return nil
+			}, mocks.Config(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Name() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVpc(t *testing.T) {
+	m := mocks.Default()
+
+	var cidr string
+	var private map[string]string
+	err := m.Run("db.go", func(ctx *pulumi.Context) error {
+		vpc, err := Vpc(ctx)
+		if err != nil {
+			return err
+		}
+
+		done := make(chan struct{})
+		pulumi.All(vpc.Cidr, vpc.PrivateSubnetsByZone).ApplyT(func(args []interface{}) error {
+			cidr, private = args[0].(string), args[1].(map[string]string)
+			close(done)
+			return nil
+		})
+		<-done
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cidr != "172.1.0.0/16" {
+		t.Errorf("Cidr = %q, want 172.1.0.0/16", cidr)
+	}
+	if private["us-west-2b"] != "subnet-private-b" {
+		t.Errorf("PrivateSubnetsByZone = %v, want subnet-private-b in us-west-2b", private)
+	}
+}
+
+func TestSelf(t *testing.T) {
+	m := mocks.New().SetStackOutputs("acme/eks.go/test", map[string]interface{}{
+		"awsAuthManaged": true,
+	})
+
+	var name string
+	var managed, missing bool
+	err := m.Run("eks.go", func(ctx *pulumi.Context) error {
+		self, err := Self(ctx)
+		if err != nil {
+			return err
+		}
+		name = self.StackName
+
+		done := make(chan struct{})
+		pulumi.All(self.GetBool("awsAuthManaged"), self.GetBool("missing")).ApplyT(func(args []interface{}) error {
+			managed, missing = args[0].(bool), args[1].(bool)
+			close(done)
+			return nil
+		})
+		<-done
+		return nil
+	}, mocks.Config(map[string]string{"iac:org": "acme"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "acme/eks.go/test" {
+		t.Errorf("StackName = %q, want acme/eks.go/test", name)
+	}
+	if !managed || missing {
+		t.Errorf("GetBool() = %v and %v, want true for the output and false for a missing one", managed, missing)
+	}
+}
+
+func TestVpcSchemaVersion(t *testing.T) {
+	m := mocks.New().SetStackOutputs("jaxxstorm/vpc.go/test", map[string]interface{}{
+		"vpc": map[string]interface{}{
+			"schemaVersion": 1,
+			"id":            "vpc-123",
+		},
+	})
+
+	err := m.Run("db.go", func(ctx *pulumi.Context) error {
+		vpc, err := Vpc(ctx)
+		if err != nil {
+			return err
+		}
+		ctx.Export("id", vpc.ID)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "has schema version 1, expected 2") {
+		t.Errorf("expected a schema version error, got %v", err)
+	}
+}
+
+func TestNewReusesReference(t *testing.T) {
+	m := mocks.Default()
+	err := m.Run("grafana.go", func(ctx *pulumi.Context) error {
+		first, err := New(ctx, "vpc")
+		if err != nil {
+			return err
+		}
+		second, err := New(ctx, "vpc")
+		if err != nil {
+			return err
+		}
+		if first != second {
+			t.Error("expected the same reference for the same stack")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if refs := m.Resources("pulumi:pulumi:StackReference"); len(refs) != 1 {
+		t.Errorf("expected a single stack reference, got %d", len(refs))
+	}
+}
+
+func TestRequireMissingOutput(t *testing.T) {
+	m := mocks.New().SetStackOutputs("jaxxstorm/eks.go/test", map[string]interface{}{})
+
+	err := m.Run("kong.go", func(ctx *pulumi.Context) error {
+		eks, err := Eks(ctx)
+		if err != nil {
+			return err
+		}
+		ctx.Export("kubeconfig", eks.Kubeconfig)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), `does not export required output "kubeconfig"`) {
+		t.Errorf("expected a missing output error, got %v", err)
+	}
+}
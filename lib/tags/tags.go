@@ -0,0 +1,288 @@
+// Package tags applies a common set of tags to every AWS resource in a stack,
+// so programs don't need to repeat them on each resource
+package tags
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jaxxstorm/iac-in-go/lib/stackref"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi/config"
+)
+
+// DefaultOwner is the Owner tag used when iac:owner isn't set
+const DefaultOwner = "lbriggs"
+
+var (
+	mapInputType            = reflect.TypeOf((*pulumi.MapInput)(nil)).Elem()
+	stringMapInputType      = reflect.TypeOf((*pulumi.StringMapInput)(nil)).Elem()
+	mapArrayInputType       = reflect.TypeOf((*pulumi.MapArrayInput)(nil)).Elem()
+	stringMapArrayInputType = reflect.TypeOf((*pulumi.StringMapArrayInput)(nil)).Elem()
+)
+
+// Get returns the tags for the current stack. It contains the Owner
+// (iac:owner), Stack, Project, CostCenter (iac:costCenter) and GitSHA
+// (iac:gitSha), plus any extra tags set in the iac:tags object. GitSHA is
+// only set from config, so deploying a new commit doesn't retag everything
+func Get(ctx *pulumi.Context) (map[string]string, error) {
+	cfg := config.New(ctx, stackref.ConfigNamespace)
+
+	tags := map[string]string{}
+	if err := cfg.GetObject("tags", &tags); err != nil {
+		return nil, fmt.Errorf("error reading %s:tags: %w", stackref.ConfigNamespace, err)
+	}
+
+	owner := cfg.Get("owner")
+	if owner == "" {
+		owner = DefaultOwner
+	}
+	tags["Owner"] = owner
+	tags["Stack"] = ctx.Stack()
+	tags["Project"] = ctx.Project()
+
+	if costCenter := cfg.Get("costCenter"); costCenter != "" {
+		tags["CostCenter"] = costCenter
+	}
+
+	if sha := cfg.Get("gitSha"); sha != "" {
+		tags["GitSHA"] = sha
+	}
+
+	return tags, nil
+}
+
+// Register adds a stack transformation which tags every AWS resource
+// created after it with the stack's tags
+func Register(ctx *pulumi.Context) error {
+	tags, err := Get(ctx)
+	if err != nil {
+		return err
+	}
+	return ctx.RegisterStackTransformation(Transformation(tags))
+}
+
+// Transformation returns a resource transformation that merges the given tags
+// into the Tags of any AWS resource that has them, as a map in pulumi-aws v2
+// or a string map from v3. Tags set on the resource itself take precedence.
+// Autoscaling groups take their tags as a list, which are propagated to the
+// group's instances. They're appended to the group's Tags when it sets any,
+// as the provider won't accept both Tags and TagsCollection, and otherwise
+// added to TagsCollection
+func Transformation(tags map[string]string) pulumi.ResourceTransformation {
+	return func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		if !strings.HasPrefix(args.Type, "aws:") {
+			return nil
+		}
+
+		props := reflect.ValueOf(args.Props)
+		if props.Kind() != reflect.Ptr || props.IsNil() || props.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+
+		// copy the props so the caller's args aren't modified
+		updated := reflect.New(props.Elem().Type())
+		updated.Elem().Set(props.Elem())
+
+		if field := updated.Elem().FieldByName("Tags"); field.IsValid() && field.Type() == mapInputType {
+			field.Set(reflect.ValueOf(mergeTags(tags, field.Interface())))
+		} else if field := updated.Elem().FieldByName("Tags"); field.IsValid() && field.Type() == stringMapInputType {
+			field.Set(reflect.ValueOf(mergeStringTags(tags, field.Interface())))
+		} else if field := updated.Elem().FieldByName("Tags"); field.IsValid() && isGroupTagArrayInput(field.Type()) && !field.IsNil() {
+			groupTags, ok := appendGroupTags(tags, field.Elem())
+			if !ok {
+				return nil
+			}
+			field.Set(groupTags)
+		} else if field := updated.Elem().FieldByName("TagsCollection"); field.IsValid() && field.Type() == mapArrayInputType {
+			field.Set(reflect.ValueOf(appendTagsCollection(tags, field.Interface())))
+		} else if field := updated.Elem().FieldByName("TagsCollection"); field.IsValid() && field.Type() == stringMapArrayInputType {
+			field.Set(reflect.ValueOf(appendStringTagsCollection(tags, field.Interface())))
+		} else {
+			return nil
+		}
+
+		return &pulumi.ResourceTransformationResult{
+			Props: updated.Interface().(pulumi.Input),
+			Opts:  args.Opts,
+		}
+	}
+}
+
+func mergeTags(tags map[string]string, existing interface{}) pulumi.MapInput {
+	merged := pulumi.Map{}
+	for k, v := range tags {
+		merged[k] = pulumi.String(v)
+	}
+
+	switch existing := existing.(type) {
+	case nil:
+		return merged
+	case pulumi.Map:
+		for k, v := range existing {
+			merged[k] = v
+		}
+		return merged
+	case pulumi.MapInput:
+		return existing.ToMapOutput().ApplyT(func(existing map[string]interface{}) map[string]interface{} {
+			result := map[string]interface{}{}
+			for k, v := range tags {
+				result[k] = v
+			}
+			for k, v := range existing {
+				result[k] = v
+			}
+			return result
+		}).(pulumi.MapOutput)
+	default:
+		return merged
+	}
+}
+
+func mergeStringTags(tags map[string]string, existing interface{}) pulumi.StringMapInput {
+	merged := pulumi.StringMap{}
+	for k, v := range tags {
+		merged[k] = pulumi.String(v)
+	}
+
+	switch existing := existing.(type) {
+	case nil:
+		return merged
+	case pulumi.StringMap:
+		for k, v := range existing {
+			merged[k] = v
+		}
+		return merged
+	case pulumi.StringMapInput:
+		return existing.ToStringMapOutput().ApplyT(func(existing map[string]string) map[string]string {
+			result := map[string]string{}
+			for k, v := range tags {
+				result[k] = v
+			}
+			for k, v := range existing {
+				result[k] = v
+			}
+			return result
+		}).(pulumi.StringMapOutput)
+	default:
+		return merged
+	}
+}
+
+func appendTagsCollection(tags map[string]string, existing interface{}) pulumi.MapArrayInput {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var collection pulumi.MapArray
+	for _, k := range keys {
+		collection = append(collection, pulumi.Map{
+			"key":                 pulumi.String(k),
+			"value":               pulumi.String(tags[k]),
+			"propagate_at_launch": pulumi.String("true"),
+		})
+	}
+
+	switch existing := existing.(type) {
+	case nil:
+		return collection
+	case pulumi.MapArray:
+		return append(existing, collection...)
+	case pulumi.MapArrayInput:
+		return pulumi.All(existing, collection).ApplyT(func(args []interface{}) []map[string]interface{} {
+			return append(args[0].([]map[string]interface{}), args[1].([]map[string]interface{})...)
+		}).(pulumi.MapArrayOutput)
+	default:
+		return collection
+	}
+}
+
+func appendStringTagsCollection(tags map[string]string, existing interface{}) pulumi.StringMapArrayInput {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var collection pulumi.StringMapArray
+	for _, k := range keys {
+		collection = append(collection, pulumi.StringMap{
+			"key":                 pulumi.String(k),
+			"value":               pulumi.String(tags[k]),
+			"propagate_at_launch": pulumi.String("true"),
+		})
+	}
+
+	switch existing := existing.(type) {
+	case nil:
+		return collection
+	case pulumi.StringMapArray:
+		return append(existing, collection...)
+	case pulumi.StringMapArrayInput:
+		return pulumi.All(existing, collection).ApplyT(func(args []interface{}) []map[string]string {
+			return append(args[0].([]map[string]string), args[1].([]map[string]string)...)
+		}).(pulumi.StringMapArrayOutput)
+	default:
+		return collection
+	}
+}
+
+// isGroupTagArrayInput reports whether the type is the GroupTagArrayInput of
+// the autoscaling package of any version of the pulumi-aws SDK
+func isGroupTagArrayInput(t reflect.Type) bool {
+	return t.Kind() == reflect.Interface && t.Name() == "GroupTagArrayInput" &&
+		strings.HasSuffix(t.PkgPath(), "/go/aws/autoscaling")
+}
+
+// appendGroupTags appends the tags whose keys aren't already set to an
+// autoscaling group's list of GroupTagArgs, propagated at launch. The new
+// entries are built from the type of the entries already in the list, so
+// it returns false for anything but a list of args, e.g. an output
+func appendGroupTags(tags map[string]string, existing reflect.Value) (reflect.Value, bool) {
+	list := reflect.Indirect(existing)
+	if list.Kind() != reflect.Slice || list.Len() == 0 {
+		return reflect.Value{}, false
+	}
+
+	var argsType reflect.Type
+	set := map[string]bool{}
+	for i := 0; i < list.Len(); i++ {
+		item := list.Index(i)
+		if item.Kind() == reflect.Interface {
+			item = item.Elem()
+		}
+		tag := reflect.Indirect(item)
+		if tag.Kind() != reflect.Struct || !tag.FieldByName("Key").IsValid() {
+			return reflect.Value{}, false
+		}
+		argsType = tag.Type()
+
+		// keys only known once deployed can't be compared
+		if key, ok := tag.FieldByName("Key").Interface().(pulumi.String); ok {
+			set[string(key)] = true
+		}
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if !set[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	// copy the list so the caller's args aren't modified
+	result := reflect.AppendSlice(reflect.MakeSlice(list.Type(), 0, list.Len()+len(keys)), list)
+	for _, k := range keys {
+		tag := reflect.New(argsType)
+		tag.Elem().FieldByName("Key").Set(reflect.ValueOf(pulumi.String(k)))
+		tag.Elem().FieldByName("Value").Set(reflect.ValueOf(pulumi.String(tags[k])))
+		tag.Elem().FieldByName("PropagateAtLaunch").Set(reflect.ValueOf(pulumi.Bool(true)))
+		result = reflect.Append(result, tag)
+	}
+	return result, true
+}
@@ -0,0 +1,236 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/autoscaling"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+type taggedArgs struct {
+	Name pulumi.StringInput
+	Tags pulumi.MapInput
+}
+
+func (taggedArgs) ElementType() reflect.Type { return reflect.TypeOf(struct{}{}) }
+
+type stringTaggedArgs struct {
+	Name pulumi.StringInput
+	Tags pulumi.StringMapInput
+}
+
+func (stringTaggedArgs) ElementType() reflect.Type { return reflect.TypeOf(struct{}{}) }
+
+type groupArgs struct {
+	Tags           pulumi.StringArrayInput
+	TagsCollection pulumi.MapArrayInput
+}
+
+func (groupArgs) ElementType() reflect.Type { return reflect.TypeOf(struct{}{}) }
+
+// stringGroupArgs has the tags collection of a pulumi-aws v3 autoscaling group
+type stringGroupArgs struct {
+	Tags           pulumi.StringArrayInput
+	TagsCollection pulumi.StringMapArrayInput
+}
+
+func (stringGroupArgs) ElementType() reflect.Type { return reflect.TypeOf(struct{}{}) }
+
+func TestGet(t *testing.T) {
+	var tags map[string]string
+	err := mocks.New().Run("db.go", func(ctx *pulumi.Context) (err error) {
+		tags, err = Get(ctx)
+		return err
+	}, mocks.Config(map[string]string{
+		"iac:owner":      "platform",
+		"iac:costCenter": "1234",
+		"iac:gitSha":     "abc1234",
+		"iac:tags":       `{"Team": "infra"}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"Owner":      "platform",
+		"Stack":      mocks.Stack,
+		"Project":    "db.go",
+		"CostCenter": "1234",
+		"GitSHA":     "abc1234",
+		"Team":       "infra",
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("Get() = %v, want %v", tags, want)
+	}
+}
+
+func TestTransformationMergesTags(t *testing.T) {
+	original := &taggedArgs{
+		Name: pulumi.String("web"),
+		Tags: pulumi.Map{"Owner": pulumi.String("someone-else")},
+	}
+
+	result := Transformation(map[string]string{"Owner": "lbriggs", "Stack": "production"})(&pulumi.ResourceTransformationArgs{
+		Type:  "aws:ec2/securityGroup:SecurityGroup",
+		Props: original,
+	})
+	if result == nil {
+		t.Fatal("expected the resource to be transformed")
+	}
+
+	tags := result.Props.(*taggedArgs).Tags.(pulumi.Map)
+	if tags["Owner"] != pulumi.String("someone-else") {
+		t.Errorf("resource tags should take precedence, got Owner %v", tags["Owner"])
+	}
+	if tags["Stack"] != pulumi.String("production") {
+		t.Errorf("expected Stack tag to be added, got %v", tags["Stack"])
+	}
+	if len(original.Tags.(pulumi.Map)) != 1 {
+		t.Error("the original args were modified")
+	}
+}
+
+func TestTransformationMergesStringTags(t *testing.T) {
+	original := &stringTaggedArgs{
+		Name: pulumi.String("cluster"),
+		Tags: pulumi.StringMap{"Owner": pulumi.String("someone-else")},
+	}
+
+	result := Transformation(map[string]string{"Owner": "lbriggs", "Stack": "production"})(&pulumi.ResourceTransformationArgs{
+		Type:  "aws:eks/cluster:Cluster",
+		Props: original,
+	})
+	if result == nil {
+		t.Fatal("expected the resource to be transformed")
+	}
+
+	tags := result.Props.(*stringTaggedArgs).Tags.(pulumi.StringMap)
+	if tags["Owner"] != pulumi.String("someone-else") {
+		t.Errorf("resource tags should take precedence, got Owner %v", tags["Owner"])
+	}
+	if tags["Stack"] != pulumi.String("production") {
+		t.Errorf("expected Stack tag to be added, got %v", tags["Stack"])
+	}
+	if len(original.Tags.(pulumi.StringMap)) != 1 {
+		t.Error("the original args were modified")
+	}
+}
+
+func TestTransformationAutoscalingGroup(t *testing.T) {
+	result := Transformation(map[string]string{"Owner": "lbriggs", "Stack": "production"})(&pulumi.ResourceTransformationArgs{
+		Type:  "aws:autoscaling/group:Group",
+		Props: &groupArgs{},
+	})
+	if result == nil {
+		t.Fatal("expected the resource to be transformed")
+	}
+
+	collection := result.Props.(*groupArgs).TagsCollection.(pulumi.MapArray)
+	if len(collection) != 2 {
+		t.Fatalf("expected 2 tags, got %d", len(collection))
+	}
+	owner := collection[0].(pulumi.Map)
+	if owner["key"] != pulumi.String("Owner") || owner["propagate_at_launch"] != pulumi.String("true") {
+		t.Errorf("expected Owner to be propagated at launch, got %v", owner)
+	}
+}
+
+func TestTransformationAutoscalingGroupStringTagsCollection(t *testing.T) {
+	original := &stringGroupArgs{
+		TagsCollection: pulumi.StringMapArray{
+			pulumi.StringMap{"key": pulumi.String("Name"), "value": pulumi.String("bastion"), "propagate_at_launch": pulumi.String("true")},
+		},
+	}
+
+	result := Transformation(map[string]string{"Owner": "lbriggs", "Stack": "production"})(&pulumi.ResourceTransformationArgs{
+		Type:  "aws:autoscaling/group:Group",
+		Props: original,
+	})
+	if result == nil {
+		t.Fatal("expected the resource to be transformed")
+	}
+
+	collection := result.Props.(*stringGroupArgs).TagsCollection.(pulumi.StringMapArray)
+	if len(collection) != 3 {
+		t.Fatalf("expected 3 tags, got %d", len(collection))
+	}
+	owner := collection[1].(pulumi.StringMap)
+	if owner["key"] != pulumi.String("Owner") || owner["propagate_at_launch"] != pulumi.String("true") {
+		t.Errorf("expected Owner to be propagated at launch, got %v", owner)
+	}
+	if len(original.TagsCollection.(pulumi.StringMapArray)) != 1 {
+		t.Error("the original args were modified")
+	}
+}
+
+func TestTransformationAutoscalingGroupTags(t *testing.T) {
+	original := &autoscaling.GroupArgs{
+		Tags: autoscaling.GroupTagArray{
+			autoscaling.GroupTagArgs{
+				Key:               pulumi.String("Name"),
+				Value:             pulumi.String("bastion"),
+				PropagateAtLaunch: pulumi.Bool(true),
+			},
+			autoscaling.GroupTagArgs{
+				Key:               pulumi.String("Owner"),
+				Value:             pulumi.String("someone-else"),
+				PropagateAtLaunch: pulumi.Bool(false),
+			},
+		},
+	}
+
+	result := Transformation(map[string]string{"Owner": "lbriggs", "Stack": "production"})(&pulumi.ResourceTransformationArgs{
+		Type:  "aws:autoscaling/group:Group",
+		Props: original,
+	})
+	if result == nil {
+		t.Fatal("expected the resource to be transformed")
+	}
+
+	args := result.Props.(*autoscaling.GroupArgs)
+	if args.TagsCollection != nil {
+		t.Error("tags should not be added to TagsCollection when the group sets Tags")
+	}
+
+	tags := args.Tags.(autoscaling.GroupTagArray)
+	if len(tags) != 3 {
+		t.Fatalf("expected 3 tags, got %d", len(tags))
+	}
+	if owner := tags[1].(autoscaling.GroupTagArgs); owner.Value != pulumi.String("someone-else") {
+		t.Errorf("resource tags should take precedence, got Owner %v", owner.Value)
+	}
+	stack := tags[2].(*autoscaling.GroupTagArgs)
+	if stack.Key != pulumi.String("Stack") || stack.Value != pulumi.String("production") || stack.PropagateAtLaunch != pulumi.Bool(true) {
+		t.Errorf("expected Stack to be propagated at launch, got %+v", stack)
+	}
+	if len(original.Tags.(autoscaling.GroupTagArray)) != 2 {
+		t.Error("the original args were modified")
+	}
+}
+
+func TestGetWithoutGitSha(t *testing.T) {
+	var tags map[string]string
+	err := mocks.New().Run("db.go", func(ctx *pulumi.Context) (err error) {
+		tags, err = Get(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sha, ok := tags["GitSHA"]; ok {
+		t.Errorf("GitSHA should only be set from config, got %q", sha)
+	}
+}
+
+func TestTransformationSkipsOtherProviders(t *testing.T) {
+	result := Transformation(map[string]string{"Owner": "lbriggs"})(&pulumi.ResourceTransformationArgs{
+		Type:  "kubernetes:core/v1:Namespace",
+		Props: &taggedArgs{},
+	})
+	if result != nil {
+		t.Error("only AWS resources should be tagged")
+	}
+}
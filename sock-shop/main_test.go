@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func TestIngress(t *testing.T) {
+	m := mocks.Default()
+	if err := m.Run("sock-shop.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	ingress, ok := m.Resource("kubernetes:networking.k8s.io/v1beta1:Ingress", "sock-shop-ingress")
+	if !ok {
+		t.Fatal("sock-shop ingress was not created")
+	}
+	annotations := ingress.Inputs["metadata"].ObjectValue()["annotations"].ObjectValue()
+	if override := annotations["konghq.com/override"].StringValue(); override != "https-redirect" {
+		t.Errorf("sock-shop ingress should use the https-redirect KongIngress, got %q", override)
+	}
+
+	found := false
+	for _, r := range m.Resources("") {
+		found = found || r.Name == "sock-shop-kong-ingress"
+	}
+	if !found {
+		t.Error("https-redirect KongIngress was not created")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// schemaVersion is the version of the "vpc" output, documented in README.md.
+// Bump it whenever a field is removed or changes meaning
+const schemaVersion = 2
+
+// children records the resources created inside the vpc component, which
+// only exposes its ID, ARN and subnet IDs
+type children struct {
+	subnets     []*ec2.Subnet
+	routeTables []*ec2.RouteTable
+	natGateways []*ec2.NatGateway
+	endpoints   []*ec2.VpcEndpoint
+}
+
+// collect registers a stack transformation which records every child
+// resource of interest as it's created
+func collect(ctx *pulumi.Context) (*children, error) {
+	c := &children{}
+	err := ctx.RegisterStackTransformation(func(args *pulumi.ResourceTransformationArgs) *pulumi.ResourceTransformationResult {
+		switch r := args.Resource.(type) {
+		case *ec2.Subnet:
+			c.subnets = append(c.subnets, r)
+		case *ec2.RouteTable:
+			c.routeTables = append(c.routeTables, r)
+		case *ec2.NatGateway:
+			c.natGateways = append(c.natGateways, r)
+		case *ec2.VpcEndpoint:
+			c.endpoints = append(c.endpoints, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// contract builds the "vpc" output consumed by the other stacks
+func (c *children) contract(id, arn pulumi.Input, cidr string, public, private pulumi.Input) pulumi.Output {
+	/*
+	 * Group the subnets by zone, using the
+	 * component's lists to tell public from private
+	 */
+	var subnets []interface{}
+	for _, subnet := range c.subnets {
+		subnets = append(subnets, subnet.ID(), subnet.AvailabilityZone)
+	}
+	byZone := pulumi.All(public, pulumi.All(subnets...)).ApplyT(func(args []interface{}) map[string]map[string]string {
+		isPublic := map[string]bool{}
+		for _, id := range args[0].([]string) {
+			isPublic[id] = true
+		}
+
+		grouped := map[string]map[string]string{
+			"public":  {},
+			"private": {},
+		}
+		values := args[1].([]interface{})
+		for i := 0; i+1 < len(values); i += 2 {
+			id, zone := string(values[i].(pulumi.ID)), values[i+1].(string)
+			if isPublic[id] {
+				grouped["public"][zone] = id
+			} else {
+				grouped["private"][zone] = id
+			}
+		}
+		return grouped
+	})
+
+	var routeTableIds []interface{}
+	for _, table := range c.routeTables {
+		routeTableIds = append(routeTableIds, table.ID())
+	}
+
+	var natGatewayIps []interface{}
+	for _, gateway := range c.natGateways {
+		natGatewayIps = append(natGatewayIps, gateway.PublicIp)
+	}
+
+	var endpoints []interface{}
+	for _, endpoint := range c.endpoints {
+		endpoints = append(endpoints, endpoint.ServiceName, endpoint.ID())
+	}
+
+	return pulumi.All(
+		id, arn, public, private, byZone,
+		pulumi.All(routeTableIds...), pulumi.All(natGatewayIps...),
+		pulumi.All(endpoints...),
+	).ApplyT(func(args []interface{}) map[string]interface{} {
+		grouped := args[4].(map[string]map[string]string)
+
+		routeTables := []string{}
+		for _, id := range args[5].([]interface{}) {
+			routeTables = append(routeTables, string(id.(pulumi.ID)))
+		}
+		sort.Strings(routeTables)
+
+		natIps := []string{}
+		for _, ip := range args[6].([]interface{}) {
+			natIps = append(natIps, ip.(string))
+		}
+		sort.Strings(natIps)
+
+		// endpoints are keyed by their service name without the region,
+		// e.g. com.amazonaws.us-west-2.ecr.api is ecr.api
+		services := map[string]string{}
+		values := args[7].([]interface{})
+		for i := 0; i+1 < len(values); i += 2 {
+			name := strings.SplitN(values[i].(string), ".", 4)
+			services[name[len(name)-1]] = string(values[i+1].(pulumi.ID))
+		}
+
+		return map[string]interface{}{
+			"schemaVersion":        schemaVersion,
+			"id":                   args[0],
+			"arn":                  args[1],
+			"cidr":                 cidr,
+			"publicSubnets":        args[2],
+			"privateSubnets":       args[3],
+			"publicSubnetsByZone":  grouped["public"],
+			"privateSubnetsByZone": grouped["private"],
+			"routeTableIds":        routeTables,
+			"natGatewayIps":        natIps,
+			"endpoints":            services,
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jaxxstorm/iac-in-go/lib/awsprovider"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+// newInterfaceEndpoints creates an interface endpoint with private DNS in the
+// private subnets for each service, e.g. "ecr.api"
+func newInterfaceEndpoints(ctx *pulumi.Context, account *awsprovider.Account, vpcID pulumi.StringOutput, cidr string, subnetIDs pulumi.StringArrayOutput, services []string) error {
+	/*
+	 * Endpoints are reached over HTTPS
+	 * from anywhere in the VPC
+	 */
+	securityGroup, err := ec2.NewSecurityGroup(ctx, "interface-endpoints", &ec2.SecurityGroupArgs{
+		VpcId:       vpcID,
+		Description: pulumi.String("Allow HTTPS to the interface VPC endpoints"),
+		Ingress: &ec2.SecurityGroupIngressArray{
+			&ec2.SecurityGroupIngressArgs{
+				Protocol: pulumi.String("tcp"),
+				FromPort: pulumi.Int(443),
+				ToPort:   pulumi.Int(443),
+				CidrBlocks: pulumi.StringArray{
+					pulumi.String(cidr),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, service := range services {
+		if service == "" || seen[service] {
+			return fmt.Errorf("interface endpoint services must be unique and not empty, got %q", services)
+		}
+		seen[service] = true
+
+		_, err := ec2.NewVpcEndpoint(ctx, service, &ec2.VpcEndpointArgs{
+			VpcId:             vpcID,
+			ServiceName:       pulumi.Sprintf("com.amazonaws.%s.%s", account.Region, service),
+			VpcEndpointType:   pulumi.String("Interface"),
+			PrivateDnsEnabled: pulumi.Bool(true),
+			SubnetIds:         subnetIDs,
+			SecurityGroupIds: pulumi.StringArray{
+				securityGroup.ID(),
+			},
+		}, pulumi.Parent(securityGroup))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
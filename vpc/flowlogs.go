@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jaxxstorm/iac-in-go/lib/awsprovider"
+	"github.com/jaxxstorm/iac-in-go/lib/policy"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+)
+
+const (
+	// cloudWatchDestination sends flow logs to a CloudWatch log group
+	cloudWatchDestination = "cloud-watch-logs"
+
+	// s3Destination sends flow logs to an S3 bucket
+	s3Destination = "s3"
+)
+
+// logRetentionDays are the retention periods CloudWatch Logs accepts
+var logRetentionDays = []int{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1827, 3653}
+
+// flowLogConfig is the vpc.go:flowLogs config object. Flow logs are
+// disabled when no destination is set
+type flowLogConfig struct {
+	Destination   string `json:"destination"`
+	RetentionDays int    `json:"retentionDays"`
+	TrafficType   string `json:"trafficType"`
+	LogFormat     string `json:"logFormat"`
+}
+
+// validate checks the config and fills in the defaults
+func (c *flowLogConfig) validate() error {
+	if c.Destination != cloudWatchDestination && c.Destination != s3Destination {
+		return fmt.Errorf("flow log destination must be %q or %q, got %q", cloudWatchDestination, s3Destination, c.Destination)
+	}
+
+	if c.RetentionDays == 0 {
+		c.RetentionDays = 14
+	}
+	if c.RetentionDays < 0 {
+		return fmt.Errorf("flow log retention must be positive, got %d days", c.RetentionDays)
+	}
+	if c.Destination == cloudWatchDestination && !validRetention(c.RetentionDays) {
+		return fmt.Errorf("flow log retention must be one of %v days for CloudWatch Logs, got %d", logRetentionDays, c.RetentionDays)
+	}
+
+	switch c.TrafficType {
+	case "":
+		c.TrafficType = "ALL"
+	case "ALL", "ACCEPT", "REJECT":
+	default:
+		return fmt.Errorf("flow log traffic type must be ALL, ACCEPT or REJECT, got %q", c.TrafficType)
+	}
+
+	return nil
+}
+
+func validRetention(days int) bool {
+	for _, valid := range logRetentionDays {
+		if days == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// newFlowLogs sends the VPC's flow logs to the configured destination
+func newFlowLogs(ctx *pulumi.Context, account *awsprovider.Account, vpcID pulumi.StringOutput, conf flowLogConfig) error {
+	if err := conf.validate(); err != nil {
+		return err
+	}
+
+	args := &ec2.FlowLogArgs{
+		VpcId:              vpcID,
+		TrafficType:        pulumi.String(conf.TrafficType),
+		LogDestinationType: pulumi.String(conf.Destination),
+	}
+	if conf.LogFormat != "" {
+		args.LogFormat = pulumi.String(conf.LogFormat)
+	}
+
+	var access pulumi.Resource
+	var err error
+	if conf.Destination == cloudWatchDestination {
+		access, err = cloudWatchFlowLogs(ctx, conf, args)
+	} else {
+		access, err = s3FlowLogs(ctx, account.ID, conf, args)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the flow log fails to deliver if it's created before it's allowed to
+	_, err = ec2.NewFlowLog(ctx, "flow-logs", args, pulumi.DependsOn([]pulumi.Resource{access}))
+	return err
+}
+
+/*
+ * CloudWatch needs a log group, and a role
+ * the flow logs service can use to write to it.
+ * The role's policy is returned
+ */
+func cloudWatchFlowLogs(ctx *pulumi.Context, conf flowLogConfig, args *ec2.FlowLogArgs) (pulumi.Resource, error) {
+	logGroup, err := cloudwatch.NewLogGroup(ctx, "flow-logs", &cloudwatch.LogGroupArgs{
+		RetentionInDays: pulumi.Int(conf.RetentionDays),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	assumeRolePolicyJSON, err := policy.ServiceAssumeRole("vpc-flow-logs.amazonaws.com").Render()
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := iam.NewRole(ctx, "flow-logs", &iam.RoleArgs{
+		AssumeRolePolicy: assumeRolePolicyJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rolePolicyJSON, err := policy.Document{
+		Statement: []policy.Statement{
+			{
+				Effect: policy.Allow,
+				Action: []string{
+					"logs:CreateLogStream",
+					"logs:PutLogEvents",
+					"logs:DescribeLogGroups",
+					"logs:DescribeLogStreams",
+				},
+				Resource: []pulumi.StringInput{
+					logGroup.Arn,
+					pulumi.Sprintf("%s:*", logGroup.Arn),
+				},
+			},
+		},
+	}.Render()
+	if err != nil {
+		return nil, err
+	}
+
+	rolePolicy, err := iam.NewRolePolicy(ctx, "flow-logs", &iam.RolePolicyArgs{
+		Role:   role.Name,
+		Policy: rolePolicyJSON,
+	}, pulumi.Parent(role))
+	if err != nil {
+		return nil, err
+	}
+
+	args.LogDestination = logGroup.Arn
+	args.IamRoleArn = role.Arn
+	return rolePolicy, nil
+}
+
+/*
+ * S3 needs a bucket which expires old logs, and
+ * a bucket policy letting the log delivery service
+ * write to it, which is returned
+ */
+func s3FlowLogs(ctx *pulumi.Context, accountID string, conf flowLogConfig, args *ec2.FlowLogArgs) (pulumi.Resource, error) {
+	bucket, err := s3.NewBucket(ctx, "flow-logs", &s3.BucketArgs{
+		Acl: pulumi.String("private"),
+		ServerSideEncryptionConfiguration: &s3.BucketServerSideEncryptionConfigurationArgs{
+			Rule: &s3.BucketServerSideEncryptionConfigurationRuleArgs{
+				ApplyServerSideEncryptionByDefault: &s3.BucketServerSideEncryptionConfigurationRuleApplyServerSideEncryptionByDefaultArgs{
+					SseAlgorithm: pulumi.String("AES256"),
+				},
+			},
+		},
+		LifecycleRules: s3.BucketLifecycleRuleArray{
+			&s3.BucketLifecycleRuleArgs{
+				Enabled: pulumi.Bool(true),
+				Expiration: &s3.BucketLifecycleRuleExpirationArgs{
+					Days: pulumi.Int(conf.RetentionDays),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := &policy.Principal{Service: policy.Strings("delivery.logs.amazonaws.com")}
+	bucketPolicyJSON, err := policy.Document{
+		Statement: []policy.Statement{
+			{
+				Sid:       "AWSLogDeliveryWrite",
+				Effect:    policy.Allow,
+				Principal: delivery,
+				Action:    []string{"s3:PutObject"},
+				Resource: []pulumi.StringInput{
+					pulumi.Sprintf("%s/AWSLogs/%s/*", bucket.Arn, accountID),
+				},
+				Condition: []policy.Condition{
+					{
+						Test:     "StringEquals",
+						Variable: pulumi.String("s3:x-amz-acl"),
+						Values:   policy.Strings("bucket-owner-full-control"),
+					},
+				},
+			},
+			{
+				Sid:       "AWSLogDeliveryAclCheck",
+				Effect:    policy.Allow,
+				Principal: delivery,
+				Action:    []string{"s3:GetBucketAcl"},
+				Resource:  []pulumi.StringInput{bucket.Arn},
+			},
+		},
+	}.Render()
+	if err != nil {
+		return nil, err
+	}
+
+	bucketPolicy, err := s3.NewBucketPolicy(ctx, "flow-logs", &s3.BucketPolicyArgs{
+		Bucket: bucket.ID(),
+		Policy: bucketPolicyJSON,
+	}, pulumi.Parent(bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	args.LogDestination = bucket.Arn
+	return bucketPolicy, nil
+}
@@ -3,6 +3,10 @@ module vpc.go
 go 1.14
 
 require (
+	github.com/jaxxstorm/iac-in-go/lib v0.0.0
 	github.com/jaxxstorm/pulumi-aws-vpc/go v0.0.0-20200713175306-b9af07a37dab
+	github.com/pulumi/pulumi-aws/sdk/v2 v2.12.0
 	github.com/pulumi/pulumi/sdk/v2 v2.6.1
 )
+
+replace github.com/jaxxstorm/iac-in-go/lib => ../lib
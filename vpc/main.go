@@ -1,44 +1,163 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+
+	"github.com/jaxxstorm/iac-in-go/lib/awsprovider"
+	"github.com/jaxxstorm/iac-in-go/lib/tags"
 	vpc "github.com/jaxxstorm/pulumi-aws-vpc/go"
+	"github.com/pulumi/pulumi-aws/sdk/v2/go/aws"
 	"github.com/pulumi/pulumi/sdk/v2/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v2/go/pulumi/config"
 )
 
 func main() {
-	pulumi.Run(func(ctx *pulumi.Context) error {
-
-		/*
-		 * Create a VPC
-		 * this uses a component resource, which is defined on line 4
-		 */
-		awsVpc, err := vpc.NewVpc(ctx, "lbriggs", vpc.Args{
-			BaseCidr:    "172.1.0.0/16",
-			Description: "lbriggs-vpc",
-			ZoneName:    "aws.lbrlabs.com",
-			AvailabilityZoneNames: pulumi.StringArray{
-				pulumi.String("us-west-2a"),
-				pulumi.String("us-west-2b"),
-				pulumi.String("us-west-2c"),
-			},
-			BaseTags: pulumi.StringMap{
-				"Owner":                         pulumi.String("lbriggs"),
-				"kubernetes.io/cluster/lbriggs": pulumi.String("shared"),
-			},
-			Endpoints: vpc.Endpoints{
-				S3:       true,
-				DynamoDB: true,
-			},
-		})
+	pulumi.Run(createStack)
+}
+
+func createStack(ctx *pulumi.Context) error {
+	/*
+	 * Tag every AWS resource in the stack
+	 */
+	if err := tags.Register(ctx); err != nil {
+		return err
+	}
+
+	/*
+	 * Look up the account and region
+	 * the stack deploys to
+	 */
+	account, err := awsprovider.Lookup(ctx)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Read the network layout from config
+	 * falling back to the original layout
+	 */
+	conf := config.New(ctx, "")
+
+	cidr := conf.Get("cidr")
+	if cidr == "" {
+		cidr = "172.1.0.0/16"
+	}
 
+	zoneName := conf.Get("zoneName")
+	if zoneName == "" {
+		zoneName = "aws.lbrlabs.com"
+	}
+
+	var zones []string
+	if err := conf.GetObject("availabilityZones", &zones); err != nil {
+		return err
+	}
+
+	/*
+	 * If no zones are configured, use the first
+	 * available zones in the region
+	 */
+	if len(zones) == 0 {
+		zoneCount := conf.GetInt("zoneCount")
+		if zoneCount == 0 {
+			zoneCount = 3
+		}
+
+		state := "available"
+		available, err := aws.GetAvailabilityZones(ctx, &aws.GetAvailabilityZonesArgs{
+			State: &state,
+		})
 		if err != nil {
 			return err
 		}
+		if len(available.Names) < zoneCount {
+			return fmt.Errorf("region only has %d available zones, %d requested", len(available.Names), zoneCount)
+		}
+
+		zones = available.Names
+		sort.Strings(zones)
+		zones = zones[:zoneCount]
+	}
 
-		ctx.Export("id", awsVpc.ID)
-		ctx.Export("arn", awsVpc.Arn)
-		ctx.Export("publicSubnets", awsVpc.PublicSubnets)
-		ctx.Export("privateSubnets", awsVpc.PrivateSubnets)
-		return nil
+	var flowLogs flowLogConfig
+	if err := conf.GetObject("flowLogs", &flowLogs); err != nil {
+		return err
+	}
+
+	// interface endpoints are billed per zone, so they're opt in
+	var interfaceEndpoints []string
+	if err := conf.GetObject("interfaceEndpoints", &interfaceEndpoints); err != nil {
+		return err
+	}
+
+	availabilityZoneNames := pulumi.StringArray{}
+	for _, zone := range zones {
+		availabilityZoneNames = append(availabilityZoneNames, pulumi.String(zone))
+	}
+
+	/*
+	 * Record the resources the component creates
+	 * so they can be exported
+	 */
+	created, err := collect(ctx)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Create a VPC
+	 * this uses a component resource, which is defined on line 4
+	 */
+	awsVpc, err := vpc.NewVpc(ctx, "lbriggs", vpc.Args{
+		BaseCidr:              cidr,
+		Description:           "lbriggs-vpc",
+		ZoneName:              zoneName,
+		AvailabilityZoneNames: availabilityZoneNames,
+		BaseTags: pulumi.StringMap{
+			"kubernetes.io/cluster/lbriggs": pulumi.String("shared"),
+		},
+		Endpoints: vpc.Endpoints{
+			S3:       true,
+			DynamoDB: true,
+		},
 	})
+
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Send the flow logs to CloudWatch
+	 * or S3 if they're enabled
+	 */
+	if flowLogs.Destination != "" {
+		if err := newFlowLogs(ctx, account, awsVpc.ID.ToStringOutput(), flowLogs); err != nil {
+			return err
+		}
+	}
+
+	/*
+	 * Create interface endpoints so workloads in
+	 * the private subnets can reach AWS APIs
+	 */
+	if len(interfaceEndpoints) > 0 {
+		err := newInterfaceEndpoints(ctx, account, awsVpc.ID.ToStringOutput(), cidr, awsVpc.PrivateSubnets, interfaceEndpoints)
+		if err != nil {
+			return err
+		}
+	}
+
+	/*
+	 * Export the structured contract other stacks read,
+	 * see README.md for the schema. The flat outputs
+	 * are kept for stacks which haven't moved over yet
+	 */
+	ctx.Export("vpc", created.contract(awsVpc.ID, awsVpc.Arn, cidr, awsVpc.PublicSubnets, awsVpc.PrivateSubnets))
+	ctx.Export("id", awsVpc.ID)
+	ctx.Export("arn", awsVpc.Arn)
+	ctx.Export("cidr", pulumi.String(cidr))
+	ctx.Export("publicSubnets", awsVpc.PublicSubnets)
+	ctx.Export("privateSubnets", awsVpc.PrivateSubnets)
+	return nil
 }
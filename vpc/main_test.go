@@ -0,0 +1,262 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaxxstorm/iac-in-go/lib/mocks"
+)
+
+func newMocks() *mocks.Mocks {
+	return mocks.New().
+		SetCallResult("aws:index/getAvailabilityZones:getAvailabilityZones", map[string]interface{}{
+			"names": []interface{}{"us-east-1d", "us-east-1a", "us-east-1c", "us-east-1b"},
+		}).
+		SetConfig("aws:region", "us-east-1")
+}
+
+func TestVpc(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("vpc.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	vpcs := m.Resources("aws:ec2/vpc:Vpc")
+	if len(vpcs) != 1 {
+		t.Fatalf("expected a single VPC, got %d", len(vpcs))
+	}
+	if cidr := vpcs[0].Inputs["cidrBlock"].StringValue(); cidr != "172.1.0.0/16" {
+		t.Errorf("unexpected VPC CIDR %s", cidr)
+	}
+
+	zones := map[string]bool{}
+	for _, subnet := range m.Resources("aws:ec2/subnet:Subnet") {
+		zones[subnet.Inputs["availabilityZone"].StringValue()] = true
+	}
+	for _, zone := range []string{"us-east-1a", "us-east-1b", "us-east-1c"} {
+		if !zones[zone] {
+			t.Errorf("expected subnets in %s, got subnets in %v", zone, zones)
+		}
+	}
+	if len(zones) != 3 {
+		t.Errorf("expected subnets in the first 3 zones, got subnets in %v", zones)
+	}
+}
+
+func TestVpcConfig(t *testing.T) {
+	m := newMocks()
+	err := m.Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:cidr":              "10.10.0.0/16",
+		"vpc.go:availabilityZones": `["eu-west-1a", "eu-west-1b"]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vpcs := m.Resources("aws:ec2/vpc:Vpc")
+	if len(vpcs) != 1 || vpcs[0].Inputs["cidrBlock"].StringValue() != "10.10.0.0/16" {
+		t.Errorf("expected a VPC with the configured CIDR, got %v", vpcs)
+	}
+	if subnets := m.Resources("aws:ec2/subnet:Subnet"); len(subnets) != 4 {
+		t.Errorf("expected a public and private subnet in each of 2 zones, got %d subnets", len(subnets))
+	}
+}
+
+func TestFlowLogsDisabled(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("vpc.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	if logs := m.Resources("aws:ec2/flowLog:FlowLog"); len(logs) != 0 {
+		t.Errorf("flow logs should be disabled by default, got %d", len(logs))
+	}
+}
+
+func TestFlowLogsCloudWatch(t *testing.T) {
+	m := newMocks().SetResourceOutputs("aws:iam/role:Role", map[string]interface{}{
+		"arn": "arn:aws:iam::123456789012:role/flow-logs",
+	})
+	err := m.Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:flowLogs": `{"destination": "cloud-watch-logs", "retentionDays": 30, "trafficType": "REJECT"}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flowLog, ok := m.Resource("aws:ec2/flowLog:FlowLog", "flow-logs")
+	if !ok {
+		t.Fatal("flow log was not created")
+	}
+	if traffic := flowLog.Inputs["trafficType"].StringValue(); traffic != "REJECT" {
+		t.Errorf("expected REJECT traffic to be logged, got %s", traffic)
+	}
+	if flowLog.Inputs["iamRoleArn"].StringValue() == "" {
+		t.Error("CloudWatch flow logs need a role")
+	}
+
+	logGroup, ok := m.Resource("aws:cloudwatch/logGroup:LogGroup", "flow-logs")
+	if !ok {
+		t.Fatal("log group was not created")
+	}
+	if days := logGroup.Inputs["retentionInDays"].NumberValue(); days != 30 {
+		t.Errorf("expected 30 days retention, got %v", days)
+	}
+
+	role, ok := m.Resource("aws:iam/role:Role", "flow-logs")
+	if !ok {
+		t.Fatal("flow logs role was not created")
+	}
+	if trust := role.Inputs["assumeRolePolicy"].StringValue(); !strings.Contains(trust, "vpc-flow-logs.amazonaws.com") {
+		t.Errorf("flow logs role should trust vpc-flow-logs.amazonaws.com, got %s", trust)
+	}
+	if _, ok := m.Resource("aws:s3/bucket:Bucket", "flow-logs"); ok {
+		t.Error("no bucket should be created for CloudWatch flow logs")
+	}
+}
+
+func TestFlowLogsS3(t *testing.T) {
+	m := newMocks()
+	err := m.Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:flowLogs": `{"destination": "s3", "logFormat": "${srcaddr} ${dstaddr}"}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flowLog, ok := m.Resource("aws:ec2/flowLog:FlowLog", "flow-logs")
+	if !ok {
+		t.Fatal("flow log was not created")
+	}
+	if traffic := flowLog.Inputs["trafficType"].StringValue(); traffic != "ALL" {
+		t.Errorf("expected all traffic to be logged by default, got %s", traffic)
+	}
+	if format := flowLog.Inputs["logFormat"].StringValue(); format != "${srcaddr} ${dstaddr}" {
+		t.Errorf("expected the configured log format, got %s", format)
+	}
+
+	bucketPolicy, ok := m.Resource("aws:s3/bucketPolicy:BucketPolicy", "flow-logs")
+	if !ok {
+		t.Fatal("bucket policy was not created")
+	}
+	document := bucketPolicy.Inputs["policy"].StringValue()
+	for _, want := range []string{"delivery.logs.amazonaws.com", "/AWSLogs/123456789012/*", "bucket-owner-full-control"} {
+		if !strings.Contains(document, want) {
+			t.Errorf("bucket policy should contain %s, got %s", want, document)
+		}
+	}
+}
+
+func TestFlowLogsInvalidDestination(t *testing.T) {
+	err := newMocks().Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:flowLogs": `{"destination": "kinesis"}`,
+	}))
+	if err == nil || !strings.Contains(err.Error(), "flow log destination") {
+		t.Errorf("expected an invalid destination error, got %v", err)
+	}
+}
+
+func TestFlowLogsInvalidRetention(t *testing.T) {
+	err := newMocks().Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:flowLogs": `{"destination": "cloud-watch-logs", "retentionDays": 10}`,
+	}))
+	if err == nil || !strings.Contains(err.Error(), "flow log retention must be one of") {
+		t.Errorf("expected an invalid retention error, got %v", err)
+	}
+
+	// buckets can expire logs after any number of days
+	err = newMocks().Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:flowLogs": `{"destination": "s3", "retentionDays": 10}`,
+	}))
+	if err != nil {
+		t.Errorf("expected any retention to be allowed for S3, got %v", err)
+	}
+}
+
+// interfaceEndpoints returns the service names of the interface endpoints
+func interfaceEndpoints(m *mocks.Mocks) map[string]mocks.Resource {
+	endpoints := map[string]mocks.Resource{}
+	for _, endpoint := range m.Resources("aws:ec2/vpcEndpoint:VpcEndpoint") {
+		if endpoint.Inputs["vpcEndpointType"].StringValue() == "Interface" {
+			endpoints[endpoint.Inputs["serviceName"].StringValue()] = endpoint
+		}
+	}
+	return endpoints
+}
+
+func TestInterfaceEndpoints(t *testing.T) {
+	m := newMocks()
+	err := m.Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:interfaceEndpoints": `["ecr.api", "ecr.dkr", "ssm", "ssmmessages", "ec2messages", "sts", "logs", "secretsmanager"]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := interfaceEndpoints(m)
+	for _, service := range []string{"ecr.api", "ecr.dkr", "ssm", "ssmmessages", "ec2messages", "sts", "logs", "secretsmanager"} {
+		endpoint, ok := endpoints["com.amazonaws.us-east-1."+service]
+		if !ok {
+			t.Errorf("expected an interface endpoint for %s", service)
+			continue
+		}
+		if !endpoint.Inputs["privateDnsEnabled"].BoolValue() {
+			t.Errorf("%s endpoint should have private DNS enabled", service)
+		}
+		if len(endpoint.Inputs["securityGroupIds"].ArrayValue()) != 1 {
+			t.Errorf("%s endpoint should use the endpoint security group", service)
+		}
+	}
+
+	sg, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "interface-endpoints")
+	if !ok {
+		t.Fatal("endpoint security group was not created")
+	}
+	vpcAllowed := false
+	for _, rule := range sg.Ingress() {
+		if rule.Allows("0.0.0.0/0", 443) {
+			t.Errorf("endpoint security group opens 443 to the world: %+v", rule)
+		}
+		vpcAllowed = vpcAllowed || rule.Allows("172.1.0.0/16", 443)
+	}
+	if !vpcAllowed {
+		t.Error("endpoint security group should allow 443 from the VPC")
+	}
+}
+
+func TestInterfaceEndpointsConfig(t *testing.T) {
+	m := newMocks()
+	err := m.Run("vpc.go", createStack, mocks.Config(map[string]string{
+		"vpc.go:interfaceEndpoints": `["sts"]`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints := interfaceEndpoints(m)
+	if _, ok := endpoints["com.amazonaws.us-east-1.sts"]; len(endpoints) != 1 || !ok {
+		t.Errorf("expected only the sts endpoint, got %v", endpoints)
+	}
+
+	m = newMocks()
+	if err := m.Run("vpc.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+	if endpoints := interfaceEndpoints(m); len(endpoints) != 0 {
+		t.Errorf("interface endpoints should be opt in, got %v", endpoints)
+	}
+	if _, ok := m.Resource("aws:ec2/securityGroup:SecurityGroup", "interface-endpoints"); ok {
+		t.Error("the endpoint security group should only be created with endpoints")
+	}
+}
+
+func TestOwnerTag(t *testing.T) {
+	m := newMocks()
+	if err := m.Run("vpc.go", createStack); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range m.MissingTag("Owner") {
+		t.Errorf("%s %s has no Owner tag", r.Type, r.Name)
+	}
+}